@@ -0,0 +1,228 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidAptConfig is returned for invalid apt settings.
+var ErrInvalidAptConfig = errors.New("invalid apt configuration")
+
+const (
+	pgpPublicKeyBegin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	pgpPublicKeyEnd   = "-----END PGP PUBLIC KEY BLOCK-----"
+)
+
+// AptConfig holds the configuration of the apt module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#apt-configure
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type AptConfig struct {
+	// PreserveSourcesList keeps the sources.list of the image, false by default in cloud-init.
+	PreserveSourcesList *bool `yaml:"preserve_sources_list,omitempty"`
+	// DisableSuites are the suites commented out in sources.list, e.g. "backports".
+	DisableSuites []string `yaml:"disable_suites,omitempty"`
+	// Primary are the mirrors of the primary archive, by architecture.
+	Primary []AptMirror `yaml:"primary,omitempty"`
+	// Security are the mirrors of the security archive, by architecture.
+	Security []AptMirror `yaml:"security,omitempty"`
+	// SourcesList is a template replacing sources.list.
+	SourcesList string `yaml:"sources_list,omitempty"`
+	// Conf is written to the apt configuration, e.g. APT::Get::Assume-Yes "true";.
+	Conf string `yaml:"conf,omitempty"`
+	// Proxy is the proxy of all protocols, an alias of HTTPProxy.
+	Proxy string `yaml:"proxy,omitempty"`
+	// HTTPProxy is the proxy of http repositories.
+	HTTPProxy string `yaml:"http_proxy,omitempty"`
+	// HTTPSProxy is the proxy of https repositories.
+	HTTPSProxy string `yaml:"https_proxy,omitempty"`
+	// FTPProxy is the proxy of ftp repositories.
+	FTPProxy string `yaml:"ftp_proxy,omitempty"`
+	// Sources are the additional repositories, by file name.
+	Sources map[string]AptSource `yaml:"sources,omitempty"`
+	// DebconfSelections are debconf-set-selections inputs, by name.
+	DebconfSelections map[string]string `yaml:"debconf_selections,omitempty"`
+}
+
+// AptMirror is a mirror of the primary or security archive.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type AptMirror struct {
+	// Arches are the architectures of the mirror, "default" for all others.
+	Arches []string `yaml:"arches"`
+	// URI is the mirror URI.
+	URI string `yaml:"uri,omitempty"`
+	// Search are URIs to try in order, the first reachable one is used.
+	Search []string `yaml:"search,omitempty"`
+	// SearchDNS looks up the mirror in DNS.
+	SearchDNS bool `yaml:"search_dns,omitempty"`
+	// KeyID is the ID of the signing key, fetched from Keyserver.
+	KeyID string `yaml:"keyid,omitempty"`
+	// Key is the ASCII-armored signing key.
+	Key string `yaml:"key,omitempty"`
+	// Keyserver is the keyserver KeyID is fetched from.
+	Keyserver string `yaml:"keyserver,omitempty"`
+}
+
+// AptSource is an additional repository.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type AptSource struct {
+	// Source is the sources.list line, e.g. "deb http://example.com/ubuntu $RELEASE main".
+	Source string `yaml:"source,omitempty"`
+	// KeyID is the ID of the signing key, fetched from Keyserver.
+	KeyID string `yaml:"keyid,omitempty"`
+	// Key is the ASCII-armored signing key.
+	Key string `yaml:"key,omitempty"`
+	// Keyserver is the keyserver KeyID is fetched from, keyserver.ubuntu.com by default.
+	Keyserver string `yaml:"keyserver,omitempty"`
+	// Filename is the file of the source in sources.list.d, the name of the source by default.
+	Filename string `yaml:"filename,omitempty"`
+	// Append appends the source to the file instead of replacing it, true by default in cloud-init.
+	Append *bool `yaml:"append,omitempty"`
+}
+
+// Validate checks the mirror and proxy URLs, the key IDs and that the keys are ASCII-armored.
+func (a *AptConfig) Validate() error {
+	for _, proxy := range []string{a.Proxy, a.HTTPProxy, a.HTTPSProxy, a.FTPProxy} {
+		if err := validateAptURL(proxy); err != nil {
+			return err
+		}
+	}
+
+	for _, mirror := range slices.Concat(a.Primary, a.Security) {
+		if err := mirror.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(a.Sources)) {
+		src := a.Sources[name]
+		if src.Source == "" && src.Key == "" && src.KeyID == "" {
+			return fmt.Errorf("%w: source %s has neither a source line nor a key", ErrInvalidAptConfig, name)
+		}
+
+		if err := validateAptKey(src.Key, src.KeyID); err != nil {
+			return fmt.Errorf("source %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m AptMirror) validate() error {
+	if len(m.Arches) == 0 {
+		return fmt.Errorf("%w: mirror without arches", ErrInvalidAptConfig)
+	}
+
+	for _, uri := range append([]string{m.URI}, m.Search...) {
+		if err := validateAptURL(uri); err != nil {
+			return err
+		}
+	}
+
+	return validateAptKey(m.Key, m.KeyID)
+}
+
+func validateAptURL(s string) error {
+	if s == "" {
+		return nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%w: invalid URL %q", ErrInvalidAptConfig, s)
+	}
+
+	return nil
+}
+
+func validateAptKey(key, keyID string) error {
+	if keyID != "" {
+		id := strings.ReplaceAll(keyID, " ", "")
+		if _, err := hex.DecodeString(id); err != nil || (len(id) != 8 && len(id) != 16 && len(id) != 40) {
+			return fmt.Errorf("%w: invalid key ID %q", ErrInvalidAptConfig, keyID)
+		}
+	}
+
+	if key != "" {
+		return ValidateArmoredKey(key)
+	}
+
+	return nil
+}
+
+// ValidateArmoredKey checks that the key is an ASCII-armored OpenPGP public
+// key block with a valid checksum, as apt-key and signed-by expect.
+func ValidateArmoredKey(key string) error {
+	lines := strings.Split(strings.TrimSpace(key), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	if len(lines) < 3 || lines[0] != pgpPublicKeyBegin || lines[len(lines)-1] != pgpPublicKeyEnd {
+		return fmt.Errorf("%w: key is not an ASCII-armored public key block", ErrInvalidAptConfig)
+	}
+
+	body := lines[1 : len(lines)-1]
+
+	// Skip the armor headers, which end with an empty line.
+	if i := slices.Index(body, ""); i >= 0 {
+		body = body[i+1:]
+	}
+
+	var checksum string
+	if n := len(body); n > 0 && strings.HasPrefix(body[n-1], "=") {
+		checksum, body = body[n-1][1:], body[:n-1]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(body, ""))
+	if err != nil || len(data) == 0 {
+		return fmt.Errorf("%w: invalid key data", ErrInvalidAptConfig)
+	}
+
+	// Every OpenPGP packet header has the high bit set.
+	if data[0]&0x80 == 0 {
+		return fmt.Errorf("%w: key data is not an OpenPGP packet", ErrInvalidAptConfig)
+	}
+
+	if checksum != "" {
+		sum, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil || len(sum) != 3 {
+			return fmt.Errorf("%w: invalid key checksum", ErrInvalidAptConfig)
+		}
+
+		if crc := crc24(data); uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) != crc {
+			return fmt.Errorf("%w: key checksum mismatch", ErrInvalidAptConfig)
+		}
+	}
+
+	return nil
+}
+
+// crc24 is the checksum of the OpenPGP armor, see RFC 4880 section 6.1.
+func crc24(data []byte) uint32 {
+	const (
+		crc24Init = 0xb704ce
+		crc24Poly = 0x1864cfb
+	)
+
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for range 8 {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+
+	return crc & 0xffffff
+}
@@ -0,0 +1,78 @@
+package cloudinit_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+const testArmoredKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatVChxYJKwYBBAHaRw8BAQdAYWMRqVaDHB0Cz3I5rxzk3WvMQs8QtMKVDB+9
+wG8bJN60HFRlc3QgUmVwbyA8cmVwb0BleGFtcGxlLmNvbT6IkAQTFggAOBYhBDkP
+ktgeR7gtpxGTzgjM++xj7au0BQJq1UKHAhsDBQsJCAcCBhUKCQgLAgQWAgMBAh4B
+AheAAAoJEAjM++xj7au0VHIA/0bKJxRRptZO98Aa0uxCVbSlBG3kqbNZJiA1vYcb
+QHM6AQC2vxjDfDKH6wHERHlSQRO3CYIAjygKh9Hl9qlJwgZSBw==
+=JKyo
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+func TestValidateArmoredKey(t *testing.T) {
+	require.NoError(t, cloudinit.ValidateArmoredKey(testArmoredKey))
+
+	for name, key := range map[string]string{
+		"binary":   "mDMEatVChxYJKwYBBAHaRw8BAQdAYWMRqVaDHB0Cz3I5rxzk3WvMQs8QtMKVDB+9",
+		"private":  strings.ReplaceAll(testArmoredKey, "PUBLIC", "PRIVATE"),
+		"checksum": strings.Replace(testArmoredKey, "=JKyo", "=JKyp", 1),
+		"corrupt":  strings.Replace(testArmoredKey, "mDME", "mDMF", 1),
+	} {
+		require.ErrorIs(t, cloudinit.ValidateArmoredKey(key), cloudinit.ErrInvalidAptConfig, name)
+	}
+}
+
+func TestAptConfig(t *testing.T) {
+	preserve := false
+	apt := &cloudinit.AptConfig{
+		PreserveSourcesList: &preserve,
+		Primary:             []cloudinit.AptMirror{{Arches: []string{"default"}, URI: "http://mirror.example.com/ubuntu"}},
+		HTTPProxy:           "http://proxy.example.com:3128",
+		Conf:                "APT::Install-Recommends \"false\";\n",
+		Sources: map[string]cloudinit.AptSource{
+			"example.list": {Source: "deb https://repo.example.com/apt $RELEASE main", Key: testArmoredKey},
+			"keyserver.list": {
+				Source:    "deb https://repo.example.org/apt $RELEASE main",
+				KeyID:     "F430BBA5",
+				Keyserver: "keyserver.ubuntu.com",
+			},
+		},
+	}
+	require.NoError(t, apt.Validate())
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{Apt: apt})
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	assert.Equal(t, apt, cc.Apt)
+	assert.Contains(t, string(c.GenerateConfigContent()), "preserve_sources_list: false\n")
+
+	invalid := []*cloudinit.AptConfig{
+		{HTTPProxy: "proxy.example.com"},
+		{Primary: []cloudinit.AptMirror{{URI: "http://mirror.example.com"}}},
+		{Sources: map[string]cloudinit.AptSource{"empty.list": {}}},
+		{Sources: map[string]cloudinit.AptSource{"bad.list": {Source: "deb x", KeyID: "xyz"}}},
+		{Sources: map[string]cloudinit.AptSource{"bad.list": {Source: "deb x", Key: "not a key"}}},
+	}
+	for _, a := range invalid {
+		require.ErrorIs(t, a.Validate(), cloudinit.ErrInvalidAptConfig)
+
+		c.SetCloudConfig(&cloudinit.CloudConfig{Apt: a})
+		assert.Nil(t, c.GenerateConfigContent())
+		require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidAptConfig)
+	}
+}
@@ -0,0 +1,103 @@
+package cloudinit
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidCACertificate is returned for certificates that can not be used as a trusted CA.
+var ErrInvalidCACertificate = errors.New("invalid CA certificate")
+
+// CACertsConfig holds the configuration of the ca_certs module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#ca-certificates
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type CACertsConfig struct {
+	// RemoveDefaults removes the CA certificates shipped with the distro.
+	RemoveDefaults bool `yaml:"remove_defaults,omitempty"`
+	// Trusted are the PEM encoded CA certificates to add.
+	Trusted []string `yaml:"trusted,omitempty"`
+}
+
+// AddTrustedCA adds a CA certificate to the trust store of the guest with the
+// ca_certs module. Expired and not yet valid certificates are added, but a
+// warning is logged, as the guest clock decides whether they are accepted.
+func (c *Config) AddTrustedCA(cert *x509.Certificate) error {
+	if cert == nil || len(cert.Raw) == 0 {
+		return fmt.Errorf("%w: empty certificate", ErrInvalidCACertificate)
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return fmt.Errorf("%w: %s is not a CA", ErrInvalidCACertificate, cert.Subject)
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("%w: %s can not sign certificates", ErrInvalidCACertificate, cert.Subject)
+	}
+
+	if now := c.now(); now.After(cert.NotAfter) {
+		c.log().Warn("trusted CA certificate has expired", "subject", cert.Subject.String(), "not_after", cert.NotAfter)
+	} else if now.Before(cert.NotBefore) {
+		c.log().Warn("trusted CA certificate is not valid yet", "subject", cert.Subject.String(), "not_before", cert.NotBefore)
+	}
+
+	c.trustedCAs = append(c.trustedCAs, cert)
+
+	return nil
+}
+
+// TrustedCAs returns the certificates added with AddTrustedCA.
+func (c *Config) TrustedCAs() []*x509.Certificate {
+	return c.trustedCAs
+}
+
+// applyCACerts writes the trusted CA certificates in PEM format.
+func (c *Config) applyCACerts(cc *CloudConfig) {
+	if len(c.trustedCAs) == 0 {
+		return
+	}
+
+	if cc.CACerts == nil {
+		cc.CACerts = new(CACertsConfig)
+	}
+
+	for _, cert := range c.trustedCAs {
+		cc.CACerts.Trusted = append(cc.CACerts.Trusted,
+			string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	}
+}
+
+// extractCACerts moves the trusted certificates written by applyCACerts back
+// into Config. Entries that are not a single PEM certificate are kept.
+func (c *Config) extractCACerts(cc *CloudConfig) {
+	if cc.CACerts == nil {
+		return
+	}
+
+	trusted := make([]string, 0, len(cc.CACerts.Trusted))
+
+	for _, entry := range cc.CACerts.Trusted {
+		block, rest := pem.Decode([]byte(entry))
+		if block == nil || block.Type != "CERTIFICATE" || strings.TrimSpace(string(rest)) != "" ||
+			string(pem.EncodeToMemory(block)) != entry {
+			trusted = append(trusted, entry)
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			trusted = append(trusted, entry)
+			continue
+		}
+
+		c.trustedCAs = append(c.trustedCAs, cert)
+	}
+
+	cc.CACerts.Trusted = trusted
+	if len(trusted) == 0 && !cc.CACerts.RemoveDefaults {
+		cc.CACerts = nil
+	}
+}
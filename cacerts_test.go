@@ -0,0 +1,77 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func testCertificate(t *testing.T, isCA bool, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Pilab Internal CA"},
+		NotBefore:             notAfter.AddDate(-10, 0, 0),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func TestAddTrustedCA(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	valid := testCertificate(t, true, now.AddDate(5, 0, 0))
+	expired := testCertificate(t, true, now.AddDate(0, -1, 0))
+
+	logs := new(bytes.Buffer)
+
+	c := cloudinit.NewConfig()
+	c.SetSourceDate(now)
+	c.SetLogger(slog.New(slog.NewTextHandler(logs, nil)))
+	c.SetCloudConfig(&cloudinit.CloudConfig{CACerts: &cloudinit.CACertsConfig{RemoveDefaults: true}})
+
+	require.NoError(t, c.AddTrustedCA(valid))
+	assert.Empty(t, logs.String())
+
+	require.NoError(t, c.AddTrustedCA(expired))
+	assert.Contains(t, logs.String(), "trusted CA certificate has expired")
+
+	require.ErrorIs(t, c.AddTrustedCA(nil), cloudinit.ErrInvalidCACertificate)
+	require.ErrorIs(t, c.AddTrustedCA(testCertificate(t, false, now.AddDate(1, 0, 0))), cloudinit.ErrInvalidCACertificate)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	require.NotNil(t, cc.CACerts)
+	assert.True(t, cc.CACerts.RemoveDefaults)
+	require.Len(t, cc.CACerts.Trusted, 2)
+
+	block, _ := pem.Decode([]byte(cc.CACerts.Trusted[0]))
+	require.NotNil(t, block)
+	assert.Equal(t, valid.Raw, block.Bytes)
+}
@@ -1,21 +1,61 @@
 package cloudinit
 
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultUserName is the name cloud-init uses for the default user of the
+// distro in the users list.
+const DefaultUserName = "default"
+
 // User holds the configuration for a user.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#users-and-groups
 //
 //nolint:tagliatelle // This format is required by the cloud-init metadata.
 type User struct {
-	// Name is the username.
+	// Name is the username, DefaultUserName for the default user.
 	Name string `yaml:"name"`
-	// Groups is a comma separated list of groups the user should be added to.
-	Groups string `yaml:"groups"`
+	// Gecos is the comment field of the user, usually the full name.
+	Gecos string `yaml:"gecos,omitempty"`
+	// PrimaryGroup is the primary group, a group named after the user by default.
+	PrimaryGroup string `yaml:"primary_group,omitempty"`
+	// Groups is a list of supplementary groups the user should be added to.
+	Groups UserGroups `yaml:"groups,omitempty"`
+	// Homedir is the home directory, /home/<name> by default.
+	Homedir string `yaml:"homedir,omitempty"`
+	// NoCreateHome skips creating the home directory.
+	NoCreateHome bool `yaml:"no_create_home,omitempty"`
+	// System creates a system user without a home directory.
+	System bool `yaml:"system,omitempty"`
+	// UID is the user ID, allocated by the system when zero.
+	UID int `yaml:"uid,omitempty"`
+	// ExpireDate is the date the account is disabled, in YYYY-MM-DD format.
+	ExpireDate string `yaml:"expiredate,omitempty"`
+	// Inactive is the number of days after password expiry until the account is disabled.
+	Inactive string `yaml:"inactive,omitempty"`
 	// Shell is the shell the user should use.
 	Shell string `yaml:"shell,omitempty"`
 	// Sudo is a list of sudo rules for the user.
 	Sudo string `yaml:"sudo,omitempty"`
+	// Doas is a list of doas rules for the user.
+	Doas []string `yaml:"doas,omitempty"`
+	// SELinuxUser is the SELinux user of the login.
+	SELinuxUser string `yaml:"selinux_user,omitempty"`
 	// AuthorizedKeys is a list of SSH public keys for the user.
 	AuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	// SSHImportID is a list of IDs to import keys from, e.g. "gh:user".
+	SSHImportID []string `yaml:"ssh_import_id,omitempty"`
+	// SSHRedirectUser rejects SSH logins and tells to use the default user instead.
+	SSHRedirectUser bool `yaml:"ssh_redirect_user,omitempty"`
 	// Password is the password hash for the user.
 	Password string `yaml:"passwd,omitempty"`
+	// HashedPassword is the password hash for the user, an alias of Password.
+	HashedPassword string `yaml:"hashed_passwd,omitempty"`
+	// PlainTextPassword is the plaintext password for the user.
+	PlainTextPassword string `yaml:"plain_text_passwd,omitempty"`
 	// LockPassword is a flag to lock the password.
 	LockPassword bool `yaml:"lock_passwd"`
 	// EnableSSHPasswordAuth if true the user can login using password over SSH.
@@ -23,37 +63,201 @@ type User struct {
 	EnableSSHPasswordAuth bool `yaml:"ssh_pwauth,omitempty"`
 }
 
+// MarshalYAML writes the default user as the plain "default" entry.
+func (u User) MarshalYAML() (interface{}, error) {
+	if u.isDefault() {
+		return DefaultUserName, nil
+	}
+
+	type plain User
+
+	return plain(u), nil
+}
+
+// UnmarshalYAML accepts the plain "default" entry besides user mappings.
+func (u *User) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Value == DefaultUserName {
+		*u = User{Name: DefaultUserName}
+		return nil
+	}
+
+	type plain User
+
+	return node.Decode((*plain)(u))
+}
+
+func (u User) isDefault() bool {
+	return u.Name == DefaultUserName
+}
+
+// Users is the users list of the cloud-config. Unlike a nil list, an empty
+// one is written as "users: []", which tells cloud-init to not create the
+// default user either.
+type Users []*User
+
+// IsZero reports whether the list is omitted.
+func (u Users) IsZero() bool {
+	return u == nil
+}
+
+// UserGroups is the list of supplementary groups of a user. It also accepts
+// the comma separated string form.
+type UserGroups []string
+
+// UnmarshalYAML accepts both a list and a comma separated string.
+func (g *UserGroups) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*g = nil
+
+		for _, group := range strings.Split(node.Value, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				*g = append(*g, group)
+			}
+		}
+
+		return nil
+	}
+
+	var groups []string
+	if err := node.Decode(&groups); err != nil {
+		return err
+	}
+
+	*g = groups
+
+	return nil
+}
+
+// Group is an entry of the top-level groups section.
+type Group struct {
+	// Name is the group name.
+	Name string
+	// Members is a list of users to add to the group.
+	Members []string
+}
+
+// MarshalYAML writes the group as its name, or as a name to members mapping.
+func (g Group) MarshalYAML() (interface{}, error) {
+	if len(g.Members) == 0 {
+		return g.Name, nil
+	}
+
+	return map[string][]string{g.Name: g.Members}, nil
+}
+
+// UnmarshalYAML accepts a group name, or a mapping of the name to a member
+// or a list of members.
+func (g *Group) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*g = Group{Name: node.Value}
+	case yaml.MappingNode:
+		if len(node.Content) != 2 {
+			return fmt.Errorf("line %d: group must have a single name", node.Line)
+		}
+
+		*g = Group{Name: node.Content[0].Value}
+
+		members := node.Content[1]
+		if members.Kind == yaml.ScalarNode {
+			g.Members = []string{members.Value}
+			return nil
+		}
+
+		return members.Decode(&g.Members)
+	default:
+		return fmt.Errorf("line %d: invalid group", node.Line)
+	}
+
+	return nil
+}
+
 // PasswordChange holds the configuration for password change on first boot.
 type PasswordChange struct {
 	// Expire is a flag to force password change on first boot.
 	Expire bool `yaml:"expire"`
-	// List is a list of users to force password change.
-	List []string `yaml:"list"`
+	// Users is a list of users and their new passwords.
+	Users []PasswordChangeUser `yaml:"users,omitempty"`
+	// List is a list of "name:password" entries.
+	//
+	// Deprecated: cloud-init deprecated the list form, use Users.
+	List []string `yaml:"list,omitempty"`
+}
+
+// PasswordType is the type of the password of a chpasswd users entry.
+type PasswordType string
+
+const (
+	// PasswordTypeHash is a password hash, e.g. from HashPassword.
+	PasswordTypeHash PasswordType = "hash"
+	// PasswordTypeText is a plaintext password.
+	PasswordTypeText PasswordType = "text"
+	// PasswordTypeRandom makes cloud-init generate the password and print it to the console.
+	PasswordTypeRandom PasswordType = "RANDOM"
+)
+
+// PasswordChangeUser holds the new password of a user.
+type PasswordChangeUser struct {
+	// Name is the username.
+	Name string `yaml:"name"`
+	// Password is the password or hash, empty for the RANDOM type.
+	Password string `yaml:"password,omitempty"`
+	// Type is the type of the password, hash when empty.
+	Type PasswordType `yaml:"type,omitempty"`
 }
 
 // CloudConfig holds the configuration for cloud-init.
 //
 //nolint:tagliatelle // This format is required by the cloud-init metadata.
 type CloudConfig struct {
+	// Groups is a list of groups to create.
+	Groups []Group `yaml:"groups,omitempty"`
 	// Users is a list of users to create.
-	Users []*User `yaml:"users,omitempty"`
+	Users Users `yaml:"users,omitempty"`
 	// PasswordChange is the configuration for password change on first boot.
 	PasswordChange PasswordChange `yaml:"chpasswd,omitempty"`
 	// PackageUpdate is a flag to update the package list.
 	PackageUpdate bool `yaml:"package_update,omitempty"`
 	// PackageUpgrade is a flag to upgrade the packages.
 	PackageUpgrade bool `yaml:"package_upgrade,omitempty"`
-	// RunCommands is a list of commands to run.
-	RunCommands []string `yaml:"runcmd,omitempty"`
-	// TODO: write_files
+	// RunCommands is a list of commands to run on the first boot.
+	RunCommands []Command `yaml:"runcmd,omitempty"`
+	// BootCommands is a list of commands to run early on every boot, before the network is up.
+	BootCommands []Command `yaml:"bootcmd,omitempty"`
+	// WriteFiles is a list of files to write.
+	WriteFiles []WriteFile `yaml:"write_files,omitempty"`
+	// Apt is the configuration of the apt module on Debian and Ubuntu.
+	Apt *AptConfig `yaml:"apt,omitempty"`
+	// YumRepos are the yum repositories to add, by repository ID.
+	YumRepos map[string]YumRepo `yaml:"yum_repos,omitempty"`
+	// YumRepoDir is the directory of the repository files, /etc/yum.repos.d by default.
+	YumRepoDir string `yaml:"yum_repo_dir,omitempty"`
+	// RHSubscription registers the guest with subscription-manager.
+	RHSubscription *RHSubscription `yaml:"rh_subscription,omitempty"`
 	// Packages is a list of packages to install.
-	Packages []string `yaml:"packages,omitempty"`
+	Packages []Package `yaml:"packages,omitempty"`
+	// PackageRebootIfRequired reboots after the package update or upgrade when the distro requires it.
+	PackageRebootIfRequired bool `yaml:"package_reboot_if_required,omitempty"`
+	// Snap is the configuration of the snap module on Ubuntu.
+	Snap *SnapConfig `yaml:"snap,omitempty"`
 	// Timezone is the timezone to set.
 	Timezone string `yaml:"timezone,omitempty"`
+	// CACerts is the configuration of the ca_certs module.
+	CACerts *CACertsConfig `yaml:"ca_certs,omitempty"`
+	// NTP is the configuration of the ntp module.
+	NTP *NTPConfig `yaml:"ntp,omitempty"`
 	// EnableSSHPasswordAuth is a flag to enable SSH password authentication for the root user.
 	EnableSSHPasswordAuth bool `yaml:"ssh_pwauth,omitempty"`
 	// Hostname is the hostname to set.
 	Hostname string `yaml:"hostname,omitempty"`
+	// FQDN is the fully qualified domain name to set, preferred over Hostname.
+	FQDN string `yaml:"fqdn,omitempty"`
+	// PreferFQDNOverHostname sets the FQDN as the system hostname.
+	PreferFQDNOverHostname bool `yaml:"prefer_fqdn_over_hostname,omitempty"`
+	// ManageEtcHosts selects how /etc/hosts is updated.
+	ManageEtcHosts EtcHostsMode `yaml:"manage_etc_hosts,omitempty"`
+	// PreserveHostname keeps the hostname set on the guest.
+	PreserveHostname bool `yaml:"preserve_hostname,omitempty"`
 	// Locale is the locale to set.
 	Locale string `yaml:"locale,omitempty"`
 	// Mounts is a list of mounts to configure.
@@ -62,6 +266,92 @@ type CloudConfig struct {
 	DisableRoot bool `yaml:"disable_root,omitempty"`
 	// Growpart is the configuration for growpart.
 	Growpart *GrowpartConfig `yaml:"growpart,omitempty"`
+	// PowerState reboots or powers off the guest after provisioning.
+	PowerState *PowerState `yaml:"power_state,omitempty"`
+	// FinalMessage is logged at the end of the final stage, cloud-init
+	// replaces $UPTIME, $TIMESTAMP, $DATASOURCE and $VERSION in it.
+	FinalMessage string `yaml:"final_message,omitempty"`
+	// PhoneHome posts the instance data to a URL after provisioning.
+	PhoneHome *PhoneHome `yaml:"phone_home,omitempty"`
+	// Ansible is the configuration of the ansible module.
+	Ansible *AnsibleConfig `yaml:"ansible,omitempty"`
+	// Puppet is the configuration of the puppet module.
+	Puppet *PuppetConfig `yaml:"puppet,omitempty"`
+	// Chef is the configuration of the chef module.
+	Chef *ChefConfig `yaml:"chef,omitempty"`
+	// SaltMinion is the configuration of the salt_minion module.
+	SaltMinion *SaltMinionConfig `yaml:"salt_minion,omitempty"`
+	// WireGuard is the configuration of the wireguard module.
+	WireGuard *WireGuardConfig `yaml:"wireguard,omitempty"`
+	// Reporting are the handlers of the cloud-init events, by name. Config
+	// moves them to the vendor-data if there is one, see SetVendorConfig.
+	Reporting map[string]ReportingHandler `yaml:"reporting,omitempty"`
+	// Output redirects the output of the cloud-init stages. Config moves it
+	// to the vendor-data if there is one, see SetVendorConfig.
+	Output *OutputConfig `yaml:"output,omitempty"`
+	// SSH is the configuration of the ssh module.
+	SSH SSHConfig `yaml:",inline"`
+}
+
+// SSHConfig holds the top-level keys of the ssh module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#ssh
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type SSHConfig struct {
+	// AuthorizedKeys is a list of SSH public keys for the default user.
+	AuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	// DisableRootOpts are the authorized_keys options of root when DisableRoot is set.
+	DisableRootOpts string `yaml:"disable_root_opts,omitempty"`
+	// AllowPublicKeys allows the keys of the data source, true by default in cloud-init.
+	AllowPublicKeys *bool `yaml:"allow_public_ssh_keys,omitempty"`
+	// QuietKeygen hides the ssh-keygen output from the console.
+	QuietKeygen bool `yaml:"ssh_quiet_keygen,omitempty"`
+	// GenKeyTypes are the host key types to generate, e.g. "ed25519".
+	GenKeyTypes []string `yaml:"ssh_genkeytypes,omitempty"`
+	// PublishHostKeys configures publishing the host keys to the data source.
+	PublishHostKeys *SSHPublishHostKeys `yaml:"ssh_publish_hostkeys,omitempty"`
+	// Keys are the SSH host keys to install instead of generated ones.
+	Keys *SSHKeys `yaml:"ssh_keys,omitempty"`
+	// DeleteKeys removes the existing host keys, true by default in cloud-init.
+	DeleteKeys *bool `yaml:"ssh_deletekeys,omitempty"`
+}
+
+// SSHPublishHostKeys holds the ssh_publish_hostkeys settings.
+type SSHPublishHostKeys struct {
+	// Enabled publishes the host keys, if the data source supports it.
+	Enabled bool `yaml:"enabled"`
+	// Blacklist are the key types not to publish.
+	Blacklist []string `yaml:"blacklist,omitempty"`
+}
+
+// SSHKeys holds the SSH host keys of the ssh_keys module.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type SSHKeys struct {
+	RSAPrivate     string `yaml:"rsa_private,omitempty"`
+	RSAPublic      string `yaml:"rsa_public,omitempty"`
+	ECDSAPrivate   string `yaml:"ecdsa_private,omitempty"`
+	ECDSAPublic    string `yaml:"ecdsa_public,omitempty"`
+	Ed25519Private string `yaml:"ed25519_private,omitempty"`
+	Ed25519Public  string `yaml:"ed25519_public,omitempty"`
+}
+
+// WriteFile is a file written by the write_files module.
+type WriteFile struct {
+	// Path is the absolute path of the file.
+	Path string `yaml:"path"`
+	// Content is the content of the file, encoded with Encoding.
+	Content string `yaml:"content,omitempty"`
+	// Encoding is the encoding of Content, e.g. "b64" or "gzip+base64", plain text when empty.
+	Encoding string `yaml:"encoding,omitempty"`
+	// Owner is the "user:group" owning the file, root:root by default.
+	Owner string `yaml:"owner,omitempty"`
+	// Permissions is the octal file mode, e.g. "0644".
+	Permissions string `yaml:"permissions,omitempty"`
+	// Append appends the content to an existing file instead of replacing it.
+	Append bool `yaml:"append,omitempty"`
+	// Defer writes the file in the final stage, after users and packages are set up.
+	Defer bool `yaml:"defer,omitempty"`
 }
 
 // Metadata holds the metadata for cloud-init.
@@ -15,7 +15,7 @@ import (
 
 var TestUser = cloudinit.User{
 	Name:           "nev3rkn0wn",
-	Groups:         "sudo,wheel,admin,forestgump",
+	Groups:         []string{"sudo", "wheel", "admin", "forestgump"},
 	Shell:          "/bin/bash",
 	Sudo:           "ALL=(ALL) NOPASSWD:ALL",
 	AuthorizedKeys: []string{"ssh-rsa AAAAB3NzaC1yc2EA... nn0wn@pm.me"},
@@ -108,3 +108,47 @@ func TestCloudInitStorageConfig(t *testing.T) {
 	assert.Contains(t, string(content), "growpart:")
 	assert.Contains(t, string(content), "/dev/vda1")
 }
+
+func TestUsersAndGroupsYAML(t *testing.T) {
+	data := []byte(`groups:
+  - admingroup: [root, sys]
+  - operators: alice
+  - cloud-users
+users:
+  - default
+  - name: alice
+    groups: sudo, adm
+    hashed_passwd: $6$salt$hash
+    uid: 1500
+  - name: bob
+    groups: [wheel]
+    system: true
+`)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(data, &cc))
+
+	assert.Equal(t, []cloudinit.Group{
+		{Name: "admingroup", Members: []string{"root", "sys"}},
+		{Name: "operators", Members: []string{"alice"}},
+		{Name: "cloud-users"},
+	}, cc.Groups)
+
+	require.Len(t, cc.Users, 3)
+	assert.Equal(t, cloudinit.DefaultUserName, cc.Users[0].Name)
+	assert.Equal(t, cloudinit.UserGroups{"sudo", "adm"}, cc.Users[1].Groups)
+	assert.Equal(t, "$6$salt$hash", cc.Users[1].HashedPassword)
+	assert.Equal(t, 1500, cc.Users[1].UID)
+	assert.Equal(t, cloudinit.UserGroups{"wheel"}, cc.Users[2].Groups)
+	assert.True(t, cc.Users[2].System)
+
+	out, err := yaml.Marshal(&cc)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "- default\n")
+	assert.Contains(t, string(out), "- admingroup:\n")
+	assert.Contains(t, string(out), "- cloud-users\n")
+
+	var again cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(out, &again))
+	assert.Equal(t, cc, again)
+}
@@ -0,0 +1,162 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scriptsDir is the directory of the scripts run by the scripts_per_* modules.
+const scriptsDir = "/var/lib/cloud/scripts/"
+
+// ErrInvalidScript is returned for scripts that cloud-init can not run.
+var ErrInvalidScript = errors.New("invalid script")
+
+// Command is an entry of runcmd, bootcmd or the snap commands. Shell commands
+// are run with sh -c, argv commands are executed directly, without quoting.
+type Command struct {
+	// Shell is the command line run with sh -c.
+	Shell string
+	// Args is the argv of the command, used when Shell is empty.
+	Args []string
+}
+
+// ShellCommand returns a command run with sh -c.
+func ShellCommand(cmd string) Command {
+	return Command{Shell: cmd}
+}
+
+// ExecCommand returns a command executed directly with the given argv.
+func ExecCommand(args ...string) Command {
+	return Command{Args: args}
+}
+
+// String returns the shell command, or the argv quoted for sh.
+func (c Command) String() string {
+	if c.Args == nil {
+		return c.Shell
+	}
+
+	quoted := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// MarshalYAML writes the shell command as a string, or the argv as a list.
+func (c Command) MarshalYAML() (interface{}, error) {
+	if c.Args == nil {
+		return c.Shell, nil
+	}
+
+	return c.Args, nil
+}
+
+// UnmarshalYAML accepts a shell command or an argv list.
+func (c *Command) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*c = Command{Shell: node.Value}
+	case yaml.SequenceNode:
+		*c = Command{Args: make([]string, 0, len(node.Content))}
+		if err := node.Decode(&c.Args); err != nil {
+			return fmt.Errorf("line %d: invalid command: %w", node.Line, err)
+		}
+	default:
+		return fmt.Errorf("line %d: invalid command", node.Line)
+	}
+
+	return nil
+}
+
+// ScriptFrequency is how often a script placed by AddScript runs.
+type ScriptFrequency string
+
+const (
+	// ScriptPerBoot runs the script on every boot.
+	ScriptPerBoot ScriptFrequency = "per-boot"
+	// ScriptPerInstance runs the script on the first boot of every instance.
+	ScriptPerInstance ScriptFrequency = "per-instance"
+	// ScriptPerOnce runs the script only once, even if the instance ID changes.
+	ScriptPerOnce ScriptFrequency = "per-once"
+)
+
+// Script is a script run by the scripts_per_boot, scripts_per_instance or
+// scripts_per_once module.
+type Script struct {
+	// Frequency is how often the script runs.
+	Frequency ScriptFrequency
+	// Name is the file name of the script, scripts run in the order of their names.
+	Name string
+	// Content is the script, starting with an interpreter line.
+	Content string
+}
+
+// Path returns the path the script is written to.
+func (s Script) Path() string {
+	return scriptsDir + string(s.Frequency) + "/" + s.Name
+}
+
+// AddScript places an executable script into the scripts directory of the
+// frequency with write_files, replacing the script of the same name.
+func (c *Config) AddScript(freq ScriptFrequency, name, content string) error {
+	switch freq {
+	case ScriptPerBoot, ScriptPerInstance, ScriptPerOnce:
+	default:
+		return fmt.Errorf("%w: unknown frequency %q", ErrInvalidScript, freq)
+	}
+
+	if name == "" || name != path.Base(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidScript, name)
+	}
+
+	if !strings.HasPrefix(content, "#!") {
+		return fmt.Errorf("%w: %s has no interpreter line", ErrInvalidScript, name)
+	}
+
+	script := Script{Frequency: freq, Name: name, Content: content}
+
+	if i := slices.IndexFunc(c.scripts, func(s Script) bool { return s.Path() == script.Path() }); i >= 0 {
+		c.scripts[i] = script
+	} else {
+		c.scripts = append(c.scripts, script)
+	}
+
+	return nil
+}
+
+// Scripts returns the scripts added with AddScript.
+func (c *Config) Scripts() []Script {
+	return c.scripts
+}
+
+// applyScripts writes the scripts with write_files.
+func (c *Config) applyScripts(cc *CloudConfig) {
+	for _, script := range c.scripts {
+		cc.WriteFiles = append(cc.WriteFiles, WriteFile{Path: script.Path(), Content: script.Content, Permissions: "0755"})
+	}
+}
+
+// extractScripts moves the scripts written by applyScripts back into Config.
+func (c *Config) extractScripts(cc *CloudConfig) {
+	cc.WriteFiles = slices.DeleteFunc(cc.WriteFiles, func(file WriteFile) bool {
+		if file.Permissions != "0755" || file.Owner != "" || file.Encoding != "" || file.Append || file.Defer {
+			return false
+		}
+
+		rel, ok := strings.CutPrefix(file.Path, scriptsDir)
+		if !ok {
+			return false
+		}
+
+		dir, name := path.Split(rel)
+
+		return c.AddScript(ScriptFrequency(strings.TrimSuffix(dir, "/")), name, file.Content) == nil
+	})
+}
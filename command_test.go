@@ -0,0 +1,57 @@
+package cloudinit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCommands(t *testing.T) {
+	cc := &cloudinit.CloudConfig{
+		BootCommands: []cloudinit.Command{cloudinit.ExecCommand("cloud-init-per", "once", "mkswap", "mkswap", "/dev/vdb")},
+		RunCommands: []cloudinit.Command{
+			cloudinit.ShellCommand("echo $HOSTNAME > /etc/motd"),
+			cloudinit.ExecCommand("touch", "/tmp/it's ok"),
+		},
+	}
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(cc)
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), `runcmd:
+    - echo $HOSTNAME > /etc/motd
+    - - touch
+      - /tmp/it's ok
+bootcmd:
+    - - cloud-init-per
+`)
+
+	var parsed cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &parsed))
+	assert.Equal(t, cc.RunCommands, parsed.RunCommands)
+	assert.Equal(t, cc.BootCommands, parsed.BootCommands)
+
+	assert.Equal(t, `'touch' '/tmp/it'\''s ok'`, cc.RunCommands[1].String())
+}
+
+func TestAddScript(t *testing.T) {
+	c := cloudinit.NewConfig()
+	require.NoError(t, c.AddScript(cloudinit.ScriptPerInstance, "10-register", "#!/bin/sh\necho old\n"))
+	require.NoError(t, c.AddScript(cloudinit.ScriptPerInstance, "10-register", "#!/bin/sh\necho new\n"))
+	require.NoError(t, c.AddScript(cloudinit.ScriptPerOnce, "20-bootstrap", "#!/usr/bin/env python3\n"))
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	assert.Equal(t, []cloudinit.WriteFile{
+		{Path: "/var/lib/cloud/scripts/per-instance/10-register", Content: "#!/bin/sh\necho new\n", Permissions: "0755"},
+		{Path: "/var/lib/cloud/scripts/per-once/20-bootstrap", Content: "#!/usr/bin/env python3\n", Permissions: "0755"},
+	}, cc.WriteFiles)
+
+	require.ErrorIs(t, c.AddScript("per-week", "x", "#!/bin/sh\n"), cloudinit.ErrInvalidScript)
+	require.ErrorIs(t, c.AddScript(cloudinit.ScriptPerBoot, "../x", "#!/bin/sh\n"), cloudinit.ErrInvalidScript)
+	require.ErrorIs(t, c.AddScript(cloudinit.ScriptPerBoot, "x", "echo no shebang"), cloudinit.ErrInvalidScript)
+}
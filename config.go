@@ -3,18 +3,28 @@ package cloudinit
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"slices"
 	"strings"
+	"text/template"
+	"time"
 
-	"github.com/kdomanski/iso9660"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
 )
 
 const VolumeName = "cidata"
 
+const (
+	guestAgentPackage = "qemu-guest-agent"
+	guestAgentService = "qemu-guest-agent"
+)
+
 type Interface struct {
 	Address     string
 	Gateway     string
@@ -23,15 +33,43 @@ type Interface struct {
 
 // Config represents a cloud-init configuration.
 type Config struct {
-	fqdn         string
-	rootPassword string
+	fqdn       string
+	instanceID string
+	passwords  []PasswordChangeUser
 
 	networkInterfaces map[string]Interface
 	users             []User
+	groups            []Group
+	defaultUser       *bool
+	sshHostKeys       []*SSHHostKey
+	trustedUserCAKeys []ssh.PublicKey
+	sshdConfig        SSHDConfig
+	scripts           []Script
+	hostname          *HostnameConfig
+	timezone          string
+	locale            string
+	ntp               *NTPConfig
+	trustedCAs        []*x509.Certificate
+	phoneHome         *PhoneHome
+	phoneHomeURL      *template.Template
+	wireGuard         []WireGuardInterface
+	kubernetesNode    *KubernetesNode
+	authorizedKeys    []string
 	enableGuestAgent  bool
 	dataSourceType    string
 	ec2Meta           *EC2Metadata
 	gceMetadata       *GCEMetadata
+	configDriveMeta   *ConfigDriveMetadata
+	cloudConfig       *CloudConfig
+	vendorConfig      *CloudConfig
+	distro            Distro
+	passwordHasher    PasswordHasher
+	packageManager    *PackageManager
+
+	now        func() time.Time
+	random     io.Reader
+	sourceDate *time.Time
+	logger     *slog.Logger
 }
 
 func NewConfig() *Config {
@@ -41,11 +79,51 @@ func NewConfig() *Config {
 
 	return &Config{
 		fqdn:              fmt.Sprintf("vps-%x.pilab.cloud", rb),
-		rootPassword:      "",
 		users:             make([]User, 0),
 		networkInterfaces: make(map[string]Interface),
 		enableGuestAgent:  false,
+		now:               time.Now,
+		random:            rand.Reader,
+	}
+}
+
+// SetClock sets the function used to get the current time, time.Now by default.
+func (c *Config) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// SetRandom sets the source of randomness used for salts, crypto/rand by
+// default. A deterministic reader makes the generated hashes reproducible.
+func (c *Config) SetRandom(r io.Reader) {
+	c.random = r
+}
+
+// SetSourceDate fixes the clock and the ISO volume timestamps to t, like
+// SOURCE_DATE_EPOCH does for reproducible builds. Together with SetRandom the
+// written images are byte-identical for the same configuration.
+func (c *Config) SetSourceDate(t time.Time) {
+	t = t.UTC()
+	c.sourceDate = &t
+	c.now = func() time.Time { return t }
+}
+
+// SetLogger sets the logger of non-fatal problems, slog.Default() by default.
+func (c *Config) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+func (c *Config) log() *slog.Logger {
+	if c.logger == nil {
+		return slog.Default()
 	}
+
+	return c.logger
+}
+
+// interfaceMACs returns the MAC addresses of the network interfaces in
+// sorted order, so the generated files do not depend on map iteration.
+func (c *Config) interfaceMACs() []string {
+	return slices.Sorted(maps.Keys(c.networkInterfaces))
 }
 
 func NewEC2Config() *Config {
@@ -63,33 +141,139 @@ func (c *Config) SetStaticInterfaceAddress(mac, addr, gateway string, ns ...stri
 	}
 }
 
-// EncryptPassword is a helper function to create a bcrypt password, for the /etc/shadow file.
-// This is used when the users are defined in the config, with plaintext password.
+// EncryptPassword is a helper function to create a password hash with
+// DefaultPasswordHasher, for the /etc/shadow file. It returns an empty string
+// if hashing fails.
+//
+// Deprecated: Use HashPassword, which reports the error.
 func EncryptPassword(password string) string {
-	// Generate a salt and hash the password using bcrypt.
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, _ := HashPassword(password, nil)
+	return hash
+}
+
+// SetPasswordHasher sets the hasher used for plaintext passwords. It takes
+// precedence over the hasher of the distro set with SetDistro.
+func (c *Config) SetPasswordHasher(hasher PasswordHasher) {
+	c.passwordHasher = hasher
+}
+
+// SetDistro sets the distribution of the guest image, which selects the
+// password hasher and the package manager, unless they are set explicitly.
+func (c *Config) SetDistro(distro Distro) {
+	c.distro = distro
+}
+
+// SetPackageManager sets the package manager used for the guest agent. It
+// takes precedence over the package manager of the distro set with SetDistro.
+func (c *Config) SetPackageManager(pm PackageManager) {
+	c.packageManager = &pm
+}
+
+// PackageManager returns the package manager of the guest.
+func (c *Config) PackageManager() PackageManager {
+	if c.packageManager != nil {
+		return *c.packageManager
+	}
+
+	return c.distro.PackageManager()
+}
+
+// hashPassword hashes the password with the configured hasher, reading the salt from c.random.
+func (c *Config) hashPassword(password string) (string, error) {
+	hasher := c.passwordHasher
+	if hasher == nil {
+		hasher = c.distro.PasswordHasher()
+	}
+
+	hash, err := hasher.Hash(password, c.random)
 	if err != nil {
-		return "" //, fmt.Errorf("failed to hash password: %w", err)
+		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Return the hash as a string suitable for /etc/shadow.
-	return fmt.Sprintf("%s", hash)
+	return hash, nil
 }
 
 // AddUser adds a user to the cloud-init configuration. If the password is not
-// already hashed, it will be hashed with MD5 hash.
-func (c *Config) AddUser(user User) {
-	if !strings.HasPrefix(user.Password, "$") {
-		user.Password = EncryptPassword(user.Password)
+// already hashed, it is hashed with the configured password hasher, so
+// SetPasswordHasher and SetDistro have to be called before.
+func (c *Config) AddUser(user User) error {
+	if user.HashedPassword != "" && !strings.HasPrefix(user.HashedPassword, "$") {
+		return fmt.Errorf("failed to add user %s: hashed password is not a crypt hash", user.Name)
+	}
+
+	if user.Password != "" && !strings.HasPrefix(user.Password, "$") {
+		hash, err := c.hashPassword(user.Password)
+		if err != nil {
+			return fmt.Errorf("failed to add user %s: %w", user.Name, err)
+		}
+
+		user.Password = hash
 	}
 
 	c.users = append(c.users, user)
+
+	return nil
+}
+
+// AddGroup adds a group to create, with the given users as members.
+func (c *Config) AddGroup(name string, members ...string) {
+	c.groups = append(c.groups, Group{Name: name, Members: members})
+}
+
+// SetDefaultUser sets whether the default user of the distro is created
+// besides the added users. cloud-init only creates it when the users list is
+// omitted, so by default it is dropped as soon as a user is added.
+func (c *Config) SetDefaultUser(keep bool) {
+	c.defaultUser = &keep
+}
+
+// SetRootPassword sets the password of the root user, see SetPassword.
+func (c *Config) SetRootPassword(password string) error {
+	return c.SetPassword("root", password)
+}
+
+// SetPassword sets the password of a user with chpasswd. If the password is
+// not already hashed, it is hashed with the configured password hasher.
+func (c *Config) SetPassword(name, password string) error {
+	if !strings.HasPrefix(password, "$") {
+		hash, err := c.hashPassword(password)
+		if err != nil {
+			return fmt.Errorf("failed to set password of %s: %w", name, err)
+		}
+
+		password = hash
+	}
+
+	entry := PasswordChangeUser{Name: name, Password: password, Type: PasswordTypeHash}
+
+	i := slices.IndexFunc(c.passwords, func(u PasswordChangeUser) bool { return u.Name == name })
+	if i >= 0 {
+		c.passwords[i] = entry
+	} else {
+		c.passwords = append(c.passwords, entry)
+	}
+
+	return nil
 }
 
-func (c *Config) SetRootPassword(password string) {
-	c.rootPassword = password
+// SetRandomPassword sets a randomly generated password for a user, and
+// returns it. Only its hash is written to the user-data. Unlike the RANDOM
+// type of chpasswd, the password is known before the instance boots.
+func (c *Config) SetRandomPassword(name string) (string, error) {
+	password, err := randomPassword(c.random)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password of %s: %w", name, err)
+	}
+
+	if err := c.SetPassword(name, password); err != nil {
+		return "", err
+	}
+
+	return password, nil
 }
 
+// SetFQDN sets the FQDN of the instance, the local-hostname of the metadata.
+// The hostname keys of the cloud-config are set with SetHostnameConfig.
 func (c *Config) SetFQDN(fqdn string) {
 	c.fqdn = fqdn
 }
@@ -98,35 +282,103 @@ func (c *Config) EnableGuestAgent() {
 	c.enableGuestAgent = true
 }
 
+// SetCloudConfig sets the cloud-config the generated user-data is based on,
+// e.g. the result of merging profiles with Merge. The settings made on Config
+// are added on top of it.
+func (c *Config) SetCloudConfig(cc *CloudConfig) {
+	c.cloudConfig = cc
+}
+
+// GenerateMetadataContent returns the NoCloud meta-data, or nil if it cannot
+// be generated. The seed writers, e.g. WriteISO, report the error.
 func (c *Config) GenerateMetadataContent() []byte {
-	hostAndDomain := strings.SplitN(c.fqdn, ".", 2)
+	content, err := c.generateMetadataContent()
+	if err != nil {
+		return nil
+	}
+
+	return content
+}
+
+func (c *Config) generateMetadataContent() ([]byte, error) {
+	id, err := c.InstanceID()
+	if err != nil {
+		return nil, err
+	}
 
 	m := Metadata{
-		InstanceID:    hostAndDomain[0],
+		InstanceID:    id,
 		LocalHostname: c.fqdn,
 	}
 
 	buf := new(bytes.Buffer)
-	_ = yaml.NewEncoder(buf).Encode(m)
+	if err := yaml.NewEncoder(buf).Encode(m); err != nil {
+		return nil, fmt.Errorf("failed to marshal meta-data: %w", err)
+	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
+// GenerateConfigContent returns the user-data, or nil if it cannot be
+// generated. The seed writers, e.g. WriteISO, report the error.
 func (c *Config) GenerateConfigContent() []byte {
-	cc := new(CloudConfig)
+	content, err := c.generateConfigContent()
+	if err != nil {
+		return nil
+	}
+
+	return content
+}
 
-	cc.Users = make([]*User, len(c.users))
-	for i, user := range c.users {
-		cc.Users[i] = &user
+func (c *Config) generateConfigContent() ([]byte, error) {
+	cc, err := cloneCloudConfig(c.cloudConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Moved to the vendor-data by generateVendorData.
+	if c.hasVendorData() {
+		cc.Reporting, cc.Output = nil, nil
 	}
 
-	if c.rootPassword != "" {
-		cc.PasswordChange = PasswordChange{
-			Expire: false,
-			List:   []string{"root:" + c.rootPassword},
+	if cc, err = c.applyKubernetesNode(cc); err != nil {
+		return nil, err
+	}
+
+	cc.Groups = append(cc.Groups, c.groups...)
+
+	for _, user := range c.users {
+		cc.Users = append(cc.Users, &user)
+	}
+
+	if c.defaultUser != nil {
+		cc.Users = slices.DeleteFunc(cc.Users, func(u *User) bool { return u.isDefault() })
+
+		if *c.defaultUser {
+			cc.Users = slices.Insert(cc.Users, 0, &User{Name: DefaultUserName})
+		} else if cc.Users == nil {
+			cc.Users = Users{}
 		}
 	}
 
+	if len(c.passwords) > 0 {
+		cc.PasswordChange.Expire = false
+		cc.PasswordChange.Users = append(cc.PasswordChange.Users, c.passwords...)
+	}
+
+	c.applyHostname(cc)
+	c.applyTime(cc)
+	c.applyCACerts(cc)
+	if err := c.applyPhoneHome(cc); err != nil {
+		return nil, err
+	}
+
+	c.applyWireGuard(cc)
+	c.applySSHHostKeys(cc)
+	c.applySSHCA(cc)
+	c.applySSHD(cc)
+	c.applyScripts(cc)
+
 	buf := new(bytes.Buffer)
 
 	// Write header
@@ -134,62 +386,64 @@ func (c *Config) GenerateConfigContent() []byte {
 
 	if c.enableGuestAgent {
 		cc.PackageUpdate = true
-		cc.Packages = append(cc.Packages, "qemu-guest-agent")
-		cc.RunCommands = append(cc.RunCommands, "systemctl enable qemu-guest-agent --now")
+		pm := c.PackageManager()
+		cc.Packages = append(cc.Packages, Package{Name: pm.GuestAgentPackage})
+		cc.RunCommands = append(cc.RunCommands, ShellCommand(pm.EnableServiceCommand(pm.GuestAgentService)))
+	}
+
+	if err := validateCloudConfig(cc); err != nil {
+		return nil, err
 	}
 
 	// Write the rest of the data
-	_ = yaml.NewEncoder(buf).Encode(cc)
+	if err := yaml.NewEncoder(buf).Encode(cc); err != nil {
+		return nil, fmt.Errorf("failed to marshal user-data: %w", err)
+	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-// WriteISO writes the cloud-init configuration to an ISO image.
-func (c *Config) WriteISO(w io.Writer) error {
-	switch c.dataSourceType {
-	case "ec2":
-		return c.writeEC2ISO(w)
-	case "gce":
-		return c.writeGCEISO(w)
-	default:
-		// FIXME: wtf?
-		// return c.writeNoCloudISO(w)
+// validateCloudConfig checks the sections of the generated user-data, so the
+// seed writers fail instead of writing a section cloud-init rejects.
+func validateCloudConfig(cc *CloudConfig) error {
+	if err := cc.SSH.Validate(); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (c *Config) writeEC2ISO(w io.Writer) error {
-	writer, err := iso9660.NewWriter()
-	if err != nil {
-		return fmt.Errorf("failed to create ISO writer: %w", err)
+	if err := ValidateReporting(cc.Reporting); err != nil {
+		return err
 	}
-	defer writer.Cleanup()
 
-	// EC2 expects metadata in JSON format
-	metadataJSON, err := json.Marshal(c.ec2Meta)
-	if err != nil {
-		return fmt.Errorf("failed to marshal EC2 metadata: %w", err)
+	if cc.Output != nil {
+		if err := cc.Output.Validate(); err != nil {
+			return err
+		}
 	}
 
-	// EC2 specific paths
-	if err := writer.AddFile(bytes.NewReader(metadataJSON), "ec2/latest/meta-data.json"); err != nil {
-		return fmt.Errorf("failed to add EC2 metadata: %w", err)
+	if cc.Apt != nil {
+		if err := cc.Apt.Validate(); err != nil {
+			return err
+		}
 	}
 
-	if err := writer.AddFile(bytes.NewReader(c.GenerateConfigContent()), "ec2/latest/user-data"); err != nil {
-		return fmt.Errorf("failed to add user-data: %w", err)
+	if err := validateConfigManagement(cc); err != nil {
+		return err
 	}
 
-	if len(c.networkInterfaces) > 0 {
-		networkData := c.generateEC2NetworkConfig()
-		if err := writer.AddFile(bytes.NewReader(networkData), "ec2/latest/network-data.json"); err != nil {
-			return fmt.Errorf("failed to add network-data: %w", err)
+	if cc.WireGuard != nil {
+		if err := cc.WireGuard.Validate(); err != nil {
+			return err
 		}
 	}
 
-	if err := writer.WriteTo(w, "ec2-seed"); err != nil {
-		return fmt.Errorf("failed to write EC2 ISO image: %w", err)
+	if err := ValidateYumRepos(cc.YumRepos); err != nil {
+		return err
+	}
+
+	if cc.RHSubscription != nil {
+		if err := cc.RHSubscription.Validate(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -215,7 +469,8 @@ func (c *Config) generateEC2NetworkConfig() []byte {
 		}, 0),
 	}
 
-	for mac, iface := range c.networkInterfaces {
+	for _, mac := range c.interfaceMACs() {
+		iface := c.networkInterfaces[mac]
 		net.Interfaces = append(net.Interfaces, struct {
 			MACAddress string   `json:"mac"`
 			IPAddress  string   `json:"ip"`
@@ -233,11 +488,19 @@ func (c *Config) generateEC2NetworkConfig() []byte {
 	return data
 }
 
-func (c *Config) SetEC2Metadata(instanceID, az string, tags map[string]string) {
+// SetEC2Metadata sets the EC2 specific metadata. A non-empty instanceID
+// replaces the instance ID of the Config, see SetInstanceID.
+func (c *Config) SetEC2Metadata(instanceID, az string, tags map[string]string) error {
+	if instanceID != "" {
+		if err := c.SetInstanceID(instanceID); err != nil {
+			return err
+		}
+	}
 	if c.ec2Meta == nil {
 		c.ec2Meta = &EC2Metadata{}
 	}
-	c.ec2Meta.InstanceID = instanceID
 	c.ec2Meta.AvailabilityZone = az
 	c.ec2Meta.Tags = tags
+
+	return nil
 }
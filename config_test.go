@@ -1,10 +1,14 @@
 package cloudinit_test
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
 )
 
 func TestCloudInitConfig_AddUser(t *testing.T) {
@@ -17,7 +21,7 @@ func TestCloudInitConfig_AddUser(t *testing.T) {
 	// Add a user
 	c.AddUser(cloudinit.User{
 		Name:           "test",
-		Groups:         "sudo",
+		Groups:         []string{"sudo"},
 		Shell:          "/bin/bash",
 		Sudo:           "",
 		AuthorizedKeys: nil,
@@ -41,3 +45,48 @@ func TestEncryptPassword(t *testing.T) {
 
 	t.Log("TestEncryptPassword: success", p)
 }
+
+func TestAddUserReproducibleHash(t *testing.T) {
+	hash := func() string {
+		c := cloudinit.NewConfig()
+		c.SetRandom(bytes.NewReader(bytes.Repeat([]byte{0x5a}, 16)))
+		c.AddUser(cloudinit.User{Name: "test", Password: "test123"})
+
+		var cc cloudinit.CloudConfig
+		require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+		require.Len(t, cc.Users, 1)
+
+		return cc.Users[0].Password
+	}
+
+	first := hash()
+	assert.Equal(t, first, hash())
+	assert.True(t, strings.HasPrefix(first, "$6$"), first)
+}
+
+func TestConfigDefaultUser(t *testing.T) {
+	users := func(c *cloudinit.Config) string {
+		var m map[string]interface{}
+		require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &m))
+
+		out, err := yaml.Marshal(m["users"])
+		require.NoError(t, err)
+
+		return string(out)
+	}
+
+	c := cloudinit.NewConfig()
+	assert.Equal(t, "null\n", users(c))
+
+	c.SetDefaultUser(false)
+	assert.Equal(t, "[]\n", users(c))
+
+	c.SetDefaultUser(true)
+	require.NoError(t, c.AddUser(cloudinit.User{Name: "admin", Groups: []string{"sudo", "adm"}}))
+	assert.Equal(t, "- default\n- groups:\n    - sudo\n    - adm\n  lock_passwd: false\n  name: admin\n", users(c))
+
+	c.AddGroup("operators", "admin")
+	assert.Contains(t, string(c.GenerateConfigContent()), "groups:\n    - operators:\n        - admin\n")
+
+	require.Error(t, c.AddUser(cloudinit.User{Name: "bob", HashedPassword: "plain"}))
+}
@@ -0,0 +1,169 @@
+package cloudinit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+// ConfigDriveVolumeName is the volume label cloud-init looks for on OpenStack config drives.
+const ConfigDriveVolumeName = "config-2"
+
+// ConfigDriveMetadata represents the OpenStack meta_data.json structure
+// For more information see: https://docs.openstack.org/nova/latest/user/metadata.html
+//
+//nolint:tagliatelle // This format is required by the OpenStack metadata.
+type ConfigDriveMetadata struct {
+	// UUID is the instance identifier
+	UUID string `json:"uuid"`
+
+	// Hostname is the hostname of the instance
+	Hostname string `json:"hostname"`
+
+	// Name is the display name of the instance
+	Name string `json:"name"`
+
+	// AvailabilityZone is the AZ where the instance is running
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+
+	// PublicKeys are the SSH public keys of the instance, by key name
+	PublicKeys map[string]string `json:"public_keys,omitempty"`
+
+	// Meta is the user provided instance metadata
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// configDriveNetworkData represents the OpenStack network_data.json structure.
+type configDriveNetworkData struct {
+	Links    []configDriveLink    `json:"links"`
+	Networks []configDriveNetwork `json:"networks"`
+	Services []configDriveService `json:"services,omitempty"`
+}
+
+//nolint:tagliatelle // This format is required by the OpenStack metadata.
+type configDriveLink struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	MACAddress string `json:"ethernet_mac_address"`
+}
+
+//nolint:tagliatelle // This format is required by the OpenStack metadata.
+type configDriveNetwork struct {
+	ID        string             `json:"id"`
+	Link      string             `json:"link"`
+	Type      string             `json:"type"`
+	IPAddress string             `json:"ip_address"`
+	Netmask   string             `json:"netmask"`
+	Routes    []configDriveRoute `json:"routes,omitempty"`
+}
+
+type configDriveRoute struct {
+	Network string `json:"network"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+}
+
+type configDriveService struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// NewConfigDriveConfig creates a configuration for the OpenStack ConfigDrive data source.
+func NewConfigDriveConfig() *Config {
+	c := NewConfig()
+	c.dataSourceType = string(DataSourceConfigDrive)
+	c.configDriveMeta = &ConfigDriveMetadata{}
+	return c
+}
+
+// SetConfigDriveMetadata sets the OpenStack specific metadata. The hostname
+// and name are derived from the FQDN when the ISO is written. A non-empty
+// uuid replaces the instance ID of the Config, see SetInstanceID.
+func (c *Config) SetConfigDriveMetadata(uuid, az string, meta map[string]string) error {
+	if uuid != "" {
+		if err := c.SetInstanceID(uuid); err != nil {
+			return err
+		}
+	}
+	if c.configDriveMeta == nil {
+		c.configDriveMeta = &ConfigDriveMetadata{}
+	}
+	c.configDriveMeta.AvailabilityZone = az
+	c.configDriveMeta.Meta = meta
+
+	return nil
+}
+
+func (c *Config) generateConfigDriveMetadata() (ConfigDriveMetadata, error) {
+	var meta ConfigDriveMetadata
+	if c.configDriveMeta != nil {
+		meta = *c.configDriveMeta
+	}
+
+	id, err := c.InstanceID()
+	if err != nil {
+		return meta, err
+	}
+
+	meta.UUID = id
+	meta.Hostname = c.fqdn
+	meta.Name = c.Hostname()
+
+	return meta, nil
+}
+
+func (c *Config) generateConfigDriveNetworkConfig() ([]byte, error) {
+	var nd configDriveNetworkData
+
+	seenDNS := make(map[string]bool)
+
+	i := 0
+	for _, mac := range c.interfaceMACs() {
+		iface := c.networkInterfaces[mac]
+
+		ip, ipNet, err := net.ParseCIDR(iface.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address of interface %s: %w", mac, err)
+		}
+
+		link := configDriveLink{ID: fmt.Sprintf("tap%d", i), Type: "phy", MACAddress: mac}
+		nd.Links = append(nd.Links, link)
+
+		network := configDriveNetwork{
+			ID:        fmt.Sprintf("network%d", i),
+			Link:      link.ID,
+			Type:      "ipv4",
+			IPAddress: ip.String(),
+			Netmask:   net.IP(ipNet.Mask).String(),
+		}
+
+		defaultRoute := configDriveRoute{Network: "0.0.0.0", Netmask: "0.0.0.0", Gateway: iface.Gateway}
+		if ip.To4() == nil {
+			network.Type = "ipv6"
+			defaultRoute.Network, defaultRoute.Netmask = "::", "::"
+		}
+
+		if iface.Gateway != "" {
+			network.Routes = append(network.Routes, defaultRoute)
+		}
+
+		nd.Networks = append(nd.Networks, network)
+
+		// DNS servers are global in the OpenStack format.
+		for _, ns := range iface.Nameservers {
+			if !seenDNS[ns] {
+				seenDNS[ns] = true
+				nd.Services = append(nd.Services, configDriveService{Type: "dns", Address: ns})
+			}
+		}
+
+		i++
+	}
+
+	data, err := json.Marshal(nd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ConfigDrive network data: %w", err)
+	}
+
+	return data, nil
+}
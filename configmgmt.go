@@ -0,0 +1,344 @@
+package cloudinit
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// ErrInvalidConfigManagement is returned for invalid ansible, puppet, chef and salt_minion settings.
+var ErrInvalidConfigManagement = errors.New("invalid configuration management settings")
+
+// chefRunListRe matches the run_list entries, e.g. "recipe[apache2]" or "role[db]".
+var chefRunListRe = regexp.MustCompile(`^(recipe|role)\[[^\[\]\s]+\]$`)
+
+// AnsibleConfig holds the configuration of the ansible module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#ansible
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type AnsibleConfig struct {
+	// InstallMethod is "distro" or "pip", distro by default.
+	InstallMethod string `yaml:"install_method,omitempty"`
+	// PackageName is the package of Ansible, "ansible" by default.
+	PackageName string `yaml:"package_name,omitempty"`
+	// RunUser is the user running Ansible, root by default.
+	RunUser string `yaml:"run_user,omitempty"`
+	// AnsibleConfig is the path of ansible.cfg.
+	AnsibleConfig string `yaml:"ansible_config,omitempty"`
+	// Galaxy are the ansible-galaxy commands run before the playbook.
+	Galaxy *AnsibleGalaxy `yaml:"galaxy,omitempty"`
+	// Pull runs a playbook with ansible-pull.
+	Pull *AnsiblePull `yaml:"pull,omitempty"`
+}
+
+// AnsibleGalaxy holds the ansible-galaxy commands of the ansible module.
+type AnsibleGalaxy struct {
+	// Actions are the argv lists of the commands, e.g. ["ansible-galaxy", "collection", "install", "community.general"].
+	Actions [][]string `yaml:"actions"`
+}
+
+// AnsiblePull holds the ansible-pull settings of the ansible module.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type AnsiblePull struct {
+	// URL is the repository of the playbook.
+	URL string `yaml:"url"`
+	// PlaybookName is the playbook to run, relative to the repository.
+	PlaybookName string `yaml:"playbook_name"`
+	// Checkout is the branch, tag or commit to check out.
+	Checkout string `yaml:"checkout,omitempty"`
+	// AcceptHostKey adds the host key of the repository to known_hosts.
+	AcceptHostKey bool `yaml:"accept_host_key,omitempty"`
+	// Clean discards local changes of the repository.
+	Clean bool `yaml:"clean,omitempty"`
+	// Full does a full clone instead of a shallow one.
+	Full bool `yaml:"full,omitempty"`
+	// Diff shows the changes made by the playbook.
+	Diff bool `yaml:"diff,omitempty"`
+	// PrivateKey is the path of the SSH key used to clone the repository.
+	PrivateKey string `yaml:"private_key,omitempty"`
+	// VaultPasswordFile is the path of the vault password file.
+	VaultPasswordFile string `yaml:"vault_password_file,omitempty"`
+	// ModulePath is the path of additional modules.
+	ModulePath string `yaml:"module_path,omitempty"`
+}
+
+// Validate checks the install method and the required pull settings.
+func (a *AnsibleConfig) Validate() error {
+	switch a.InstallMethod {
+	case "", "distro", "pip":
+	default:
+		return fmt.Errorf("%w: ansible install method %q", ErrInvalidConfigManagement, a.InstallMethod)
+	}
+
+	if a.Galaxy != nil {
+		for _, action := range a.Galaxy.Actions {
+			if len(action) == 0 {
+				return fmt.Errorf("%w: empty ansible galaxy action", ErrInvalidConfigManagement)
+			}
+		}
+	}
+
+	if a.Pull != nil {
+		if a.Pull.URL == "" || a.Pull.PlaybookName == "" {
+			return fmt.Errorf("%w: ansible pull requires url and playbook_name", ErrInvalidConfigManagement)
+		}
+	}
+
+	return nil
+}
+
+// PuppetConfig holds the configuration of the puppet module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#puppet
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type PuppetConfig struct {
+	// Install installs Puppet, true by default in cloud-init.
+	Install *bool `yaml:"install,omitempty"`
+	// Version is the version to install, the latest when empty.
+	Version string `yaml:"version,omitempty"`
+	// InstallType is "packages" or "aio", packages by default.
+	InstallType string `yaml:"install_type,omitempty"`
+	// Collection is the Puppet collection of an aio install, e.g. "puppet8".
+	Collection string `yaml:"collection,omitempty"`
+	// AIOInstallURL is the URL of the aio install script.
+	AIOInstallURL string `yaml:"aio_install_url,omitempty"`
+	// Cleanup removes the aio install script afterwards, true by default in cloud-init.
+	Cleanup *bool `yaml:"cleanup,omitempty"`
+	// PackageName is the package of Puppet.
+	PackageName string `yaml:"package_name,omitempty"`
+	// ConfFile is the path of puppet.conf.
+	ConfFile string `yaml:"conf_file,omitempty"`
+	// SSLDir is the SSL directory of Puppet.
+	SSLDir string `yaml:"ssl_dir,omitempty"`
+	// CSRAttributesPath is the path of csr_attributes.yaml.
+	CSRAttributesPath string `yaml:"csr_attributes_path,omitempty"`
+	// Exec runs the Puppet agent after the install.
+	Exec bool `yaml:"exec,omitempty"`
+	// ExecArgs are the arguments of the Puppet agent, "--test" by default.
+	ExecArgs []string `yaml:"exec_args,omitempty"`
+	// StartService enables and starts the Puppet service, true by default in cloud-init.
+	StartService *bool `yaml:"start_service,omitempty"`
+	// Conf are the puppet.conf settings.
+	Conf *PuppetConf `yaml:"conf,omitempty"`
+	// CSRAttributes are written to csr_attributes.yaml.
+	CSRAttributes *PuppetCSRAttributes `yaml:"csr_attributes,omitempty"`
+}
+
+// PuppetConf holds the puppet.conf settings by section, and the CA certificate.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type PuppetConf struct {
+	// CACert is the PEM encoded certificate of the Puppet CA.
+	CACert string `yaml:"ca_cert,omitempty"`
+	// Sections are the settings by section, "main", "server", "agent" or "user".
+	Sections map[string]map[string]string `yaml:",inline"`
+}
+
+// PuppetCSRAttributes holds the attributes of the certificate signing request.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type PuppetCSRAttributes struct {
+	// CustomAttributes are added to the CSR but not to the certificate, e.g. a challenge password.
+	CustomAttributes map[string]string `yaml:"custom_attributes,omitempty"`
+	// ExtensionRequests are added to the certificate, e.g. pp_role.
+	ExtensionRequests map[string]string `yaml:"extension_requests,omitempty"`
+}
+
+// Validate checks the install type and the puppet.conf sections.
+func (p *PuppetConfig) Validate() error {
+	switch p.InstallType {
+	case "", "packages":
+		if p.Collection != "" || p.AIOInstallURL != "" {
+			return fmt.Errorf("%w: puppet collection requires the aio install type", ErrInvalidConfigManagement)
+		}
+	case "aio":
+	default:
+		return fmt.Errorf("%w: puppet install type %q", ErrInvalidConfigManagement, p.InstallType)
+	}
+
+	if p.Conf == nil {
+		return nil
+	}
+
+	for section := range p.Conf.Sections {
+		switch section {
+		case "main", "server", "master", "agent", "user":
+		default:
+			return fmt.Errorf("%w: unknown puppet.conf section %q", ErrInvalidConfigManagement, section)
+		}
+	}
+
+	if p.Conf.CACert != "" {
+		if block, _ := pem.Decode([]byte(p.Conf.CACert)); block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("%w: puppet ca_cert is not a PEM certificate", ErrInvalidConfigManagement)
+		}
+	}
+
+	return nil
+}
+
+// ChefConfig holds the configuration of the chef module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#chef
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type ChefConfig struct {
+	// InstallType is "packages", "gems" or "omnibus", packages by default.
+	InstallType string `yaml:"install_type,omitempty"`
+	// ForceInstall installs Chef even if it is installed.
+	ForceInstall bool `yaml:"force_install,omitempty"`
+	// OmnibusURL is the URL of the omnibus install script.
+	OmnibusURL string `yaml:"omnibus_url,omitempty"`
+	// OmnibusVersion is the version installed with omnibus.
+	OmnibusVersion string `yaml:"omnibus_version,omitempty"`
+	// ServerURL is the URL of the Chef server.
+	ServerURL string `yaml:"server_url"`
+	// NodeName is the name of the node, the instance ID by default.
+	NodeName string `yaml:"node_name,omitempty"`
+	// Environment is the Chef environment, "_default" by default.
+	Environment string `yaml:"environment,omitempty"`
+	// ValidationName is the name of the validation client.
+	ValidationName string `yaml:"validation_name"`
+	// ValidationKey is the path the validation key is written to.
+	ValidationKey string `yaml:"validation_key,omitempty"`
+	// ValidationCert is the PEM encoded validation key, or "system" to keep the existing one.
+	ValidationCert string `yaml:"validation_cert,omitempty"`
+	// RunList are the recipes and roles of the first run.
+	RunList []string `yaml:"run_list,omitempty"`
+	// InitialAttributes are the node attributes of the first run.
+	InitialAttributes map[string]interface{} `yaml:"initial_attributes,omitempty"`
+	// ChefLicense accepts the Chef license, "accept", "accept-silent" or "accept-no-persist".
+	ChefLicense string `yaml:"chef_license,omitempty"`
+	// Exec runs the Chef client after the install.
+	Exec *bool `yaml:"exec,omitempty"`
+	// SSLVerifyMode is ":verify_none" or ":verify_peer".
+	SSLVerifyMode string `yaml:"ssl_verify_mode,omitempty"`
+	// EncryptedDataBagSecret is the path of the encrypted data bag secret.
+	EncryptedDataBagSecret string `yaml:"encrypted_data_bag_secret,omitempty"`
+	// LogLevel is the log level of the Chef client, e.g. ":info".
+	LogLevel string `yaml:"log_level,omitempty"`
+}
+
+// Validate checks the server URL, the validation client and the run list.
+func (c *ChefConfig) Validate() error {
+	switch c.InstallType {
+	case "", "packages", "gems", "omnibus":
+	default:
+		return fmt.Errorf("%w: chef install type %q", ErrInvalidConfigManagement, c.InstallType)
+	}
+
+	u, err := url.Parse(c.ServerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: invalid chef server_url %q", ErrInvalidConfigManagement, c.ServerURL)
+	}
+
+	if c.ValidationName == "" {
+		return fmt.Errorf("%w: chef requires validation_name", ErrInvalidConfigManagement)
+	}
+
+	if c.ValidationCert != "" && c.ValidationCert != "system" {
+		if block, _ := pem.Decode([]byte(c.ValidationCert)); block == nil {
+			return fmt.Errorf("%w: chef validation_cert is not PEM encoded", ErrInvalidConfigManagement)
+		}
+	}
+
+	for _, entry := range c.RunList {
+		if !chefRunListRe.MatchString(entry) {
+			return fmt.Errorf("%w: invalid chef run_list entry %q", ErrInvalidConfigManagement, entry)
+		}
+	}
+
+	switch c.ChefLicense {
+	case "", "accept", "accept-silent", "accept-no-persist":
+	default:
+		return fmt.Errorf("%w: chef license %q", ErrInvalidConfigManagement, c.ChefLicense)
+	}
+
+	switch c.SSLVerifyMode {
+	case "", ":verify_none", ":verify_peer":
+	default:
+		return fmt.Errorf("%w: chef ssl_verify_mode %q", ErrInvalidConfigManagement, c.SSLVerifyMode)
+	}
+
+	return nil
+}
+
+// SaltMinionConfig holds the configuration of the salt_minion module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#salt-minion
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type SaltMinionConfig struct {
+	// PkgName is the package of the minion, "salt-minion" by default.
+	PkgName string `yaml:"pkg_name,omitempty"`
+	// ServiceName is the service of the minion, "salt-minion" by default.
+	ServiceName string `yaml:"service_name,omitempty"`
+	// ConfigDir is the configuration directory, /etc/salt by default.
+	ConfigDir string `yaml:"config_dir,omitempty"`
+	// Conf are the minion settings, e.g. master.
+	Conf map[string]interface{} `yaml:"conf,omitempty"`
+	// Grains are the static grains of the minion.
+	Grains map[string]interface{} `yaml:"grains,omitempty"`
+	// PublicKey is the PEM encoded public key of the minion, pre-accepted on the master.
+	PublicKey string `yaml:"public_key,omitempty"`
+	// PrivateKey is the PEM encoded private key of the minion.
+	PrivateKey string `yaml:"private_key,omitempty"`
+	// PKIDir is the directory of the keys, /etc/salt/pki/minion by default.
+	PKIDir string `yaml:"pki_dir,omitempty"`
+}
+
+// Validate checks that the minion has a master, or runs masterless, and
+// that the keys are PEM encoded pairs.
+func (s *SaltMinionConfig) Validate() error {
+	if master, _ := s.Conf["master"].(string); master == "" {
+		if list, _ := s.Conf["master"].([]interface{}); len(list) == 0 && s.Conf["file_client"] != "local" {
+			return fmt.Errorf("%w: salt minion requires a master or file_client: local", ErrInvalidConfigManagement)
+		}
+	}
+
+	if (s.PublicKey == "") != (s.PrivateKey == "") {
+		return fmt.Errorf("%w: salt minion requires both public_key and private_key", ErrInvalidConfigManagement)
+	}
+
+	for _, key := range []string{s.PublicKey, s.PrivateKey} {
+		if key == "" {
+			continue
+		}
+
+		if block, _ := pem.Decode([]byte(key)); block == nil {
+			return fmt.Errorf("%w: salt minion key is not PEM encoded", ErrInvalidConfigManagement)
+		}
+	}
+
+	return nil
+}
+
+// validateConfigManagement checks the ansible, puppet, chef and salt_minion sections.
+func validateConfigManagement(cc *CloudConfig) error {
+	if cc.Ansible != nil {
+		if err := cc.Ansible.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if cc.Puppet != nil {
+		if err := cc.Puppet.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if cc.Chef != nil {
+		if err := cc.Chef.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if cc.SaltMinion != nil {
+		if err := cc.SaltMinion.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
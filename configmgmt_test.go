@@ -0,0 +1,79 @@
+package cloudinit_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigManagement(t *testing.T) {
+	ansible := &cloudinit.AnsibleConfig{
+		InstallMethod: "pip",
+		Galaxy:        &cloudinit.AnsibleGalaxy{Actions: [][]string{{"ansible-galaxy", "collection", "install", "community.general"}}},
+		Pull:          &cloudinit.AnsiblePull{URL: "https://git.example.com/ops/site.git", PlaybookName: "site.yml", Checkout: "main"},
+	}
+	puppet := &cloudinit.PuppetConfig{
+		InstallType: "aio",
+		Collection:  "puppet8",
+		Exec:        true,
+		Conf: &cloudinit.PuppetConf{
+			Sections: map[string]map[string]string{"agent": {"server": "puppet.example.com", "certname": "web1"}},
+		},
+		CSRAttributes: &cloudinit.PuppetCSRAttributes{ExtensionRequests: map[string]string{"pp_role": "web"}},
+	}
+	chef := &cloudinit.ChefConfig{
+		ServerURL:      "https://chef.example.com/organizations/ops",
+		ValidationName: "ops-validator",
+		ValidationCert: "system",
+		RunList:        []string{"recipe[base]", "role[web]"},
+		ChefLicense:    "accept",
+	}
+	salt := &cloudinit.SaltMinionConfig{
+		Conf:   map[string]interface{}{"master": "salt.example.com"},
+		Grains: map[string]interface{}{"role": "web"},
+	}
+
+	require.NoError(t, ansible.Validate())
+	require.NoError(t, puppet.Validate())
+	require.NoError(t, chef.Validate())
+	require.NoError(t, salt.Validate())
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{Ansible: ansible, Puppet: puppet, Chef: chef, SaltMinion: salt})
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), "    conf:\n        agent:\n            certname: web1\n            server: puppet.example.com\n")
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, ansible, cc.Ansible)
+	assert.Equal(t, puppet, cc.Puppet)
+	assert.Equal(t, chef, cc.Chef)
+	assert.Equal(t, salt, cc.SaltMinion)
+
+	for name, err := range map[string]error{
+		"ansible pull":    (&cloudinit.AnsibleConfig{Pull: &cloudinit.AnsiblePull{URL: "https://git.example.com/x.git"}}).Validate(),
+		"ansible install": (&cloudinit.AnsibleConfig{InstallMethod: "brew"}).Validate(),
+		"puppet section":  (&cloudinit.PuppetConfig{Conf: &cloudinit.PuppetConf{Sections: map[string]map[string]string{"x": {}}}}).Validate(),
+		"puppet ca_cert":  (&cloudinit.PuppetConfig{Conf: &cloudinit.PuppetConf{CACert: "not a cert"}}).Validate(),
+		"puppet packages": (&cloudinit.PuppetConfig{Collection: "puppet8"}).Validate(),
+		"chef server":     (&cloudinit.ChefConfig{ValidationName: "v"}).Validate(),
+		"chef validation": (&cloudinit.ChefConfig{ServerURL: "https://chef.example.com"}).Validate(),
+		"chef run_list":   (&cloudinit.ChefConfig{ServerURL: "https://chef.example.com", ValidationName: "v", RunList: []string{"base"}}).Validate(),
+		"salt master":     (&cloudinit.SaltMinionConfig{}).Validate(),
+		"salt key pair":   (&cloudinit.SaltMinionConfig{Conf: map[string]interface{}{"file_client": "local"}, PublicKey: "x"}).Validate(),
+	} {
+		require.ErrorIs(t, err, cloudinit.ErrInvalidConfigManagement, name)
+	}
+
+	// The sections are validated when the seed is written.
+	c.SetCloudConfig(&cloudinit.CloudConfig{Chef: &cloudinit.ChefConfig{}})
+	assert.Nil(t, c.GenerateConfigContent())
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidConfigManagement)
+	require.ErrorIs(t, c.WriteDir(t.TempDir()), cloudinit.ErrInvalidConfigManagement)
+	require.ErrorIs(t, c.WriteTar(io.Discard), cloudinit.ErrInvalidConfigManagement)
+}
@@ -134,7 +134,7 @@ func TestDataSourceCompatibility(t *testing.T) {
 			// Add common configuration
 			c.AddUser(cloudinit.User{
 				Name:   "test-user",
-				Groups: "sudo",
+				Groups: []string{"sudo"},
 				Shell:  "/bin/bash",
 			})
 
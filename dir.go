@@ -0,0 +1,106 @@
+package cloudinit
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Modes of the seed files and directories. The user-data holds password
+// hashes and private keys, so only the owner may read it.
+const (
+	seedFileMode = 0o600
+	seedDirMode  = 0o700
+)
+
+// WriteDir writes the files of the seed below path, with the same layout as
+// on the ISO image, e.g. for NoCloud seedfrom=file:// or Proxmox snippets.
+// Directories are created as needed, existing files are overwritten and
+// made readable by the owner only.
+func (c *Config) WriteDir(path string) error {
+	_, files, err := c.seedFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		target := filepath.Join(path, filepath.FromSlash(f.path))
+
+		if err := os.MkdirAll(filepath.Dir(target), seedDirMode); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", f.path, err)
+		}
+
+		if err := os.WriteFile(target, f.data, seedFileMode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+
+		// WriteFile keeps the mode of existing files.
+		if err := os.Chmod(target, seedFileMode); err != nil {
+			return fmt.Errorf("failed to set the mode of %s: %w", f.path, err)
+		}
+	}
+
+	return nil
+}
+
+// WriteTar writes the files of the seed as a tar archive, with the same
+// layout as on the ISO image.
+func (c *Config) WriteTar(w io.Writer) error {
+	_, files, err := c.seedFiles()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := c.now()
+	written := make(map[string]bool)
+
+	for _, f := range files {
+		// Add the parent directories first, so every tool can extract the archive.
+		parts := strings.Split(f.path, "/")
+		for i := 1; i < len(parts); i++ {
+			dir := strings.Join(parts[:i], "/") + "/"
+			if written[dir] {
+				continue
+			}
+
+			hdr := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     dir,
+				Mode:     seedDirMode,
+				ModTime:  modTime,
+			}
+
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("failed to write tar header of %s: %w", dir, err)
+			}
+
+			written[dir] = true
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.path,
+			Mode:     seedFileMode,
+			Size:     int64(len(f.data)),
+			ModTime:  modTime,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write tar header of %s: %w", f.path, err)
+		}
+
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar archive: %w", err)
+	}
+
+	return nil
+}
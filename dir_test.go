@@ -0,0 +1,94 @@
+package cloudinit_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestWriteDir(t *testing.T) {
+	c := cloudinit.NewEC2Config()
+	require.NoError(t, c.SetEC2Metadata("i-1234567890", "us-east-1a", nil))
+	c.SetStaticInterfaceAddress("0e:49:61:0f:c3:11", "172.31.16.100/20", "172.31.16.1", "169.254.169.253")
+
+	dir := t.TempDir()
+	require.NoError(t, c.WriteDir(dir))
+
+	userData, err := os.ReadFile(filepath.Join(dir, "ec2", "latest", "user-data"))
+	require.NoError(t, err)
+	assert.Equal(t, c.GenerateConfigContent(), userData)
+
+	assert.FileExists(t, filepath.Join(dir, "ec2", "latest", "meta-data.json"))
+	assert.FileExists(t, filepath.Join(dir, "ec2", "latest", "network-data.json"))
+
+	assertMode(t, filepath.Join(dir, "ec2"), os.ModeDir|0o700)
+	assertMode(t, filepath.Join(dir, "ec2", "latest"), os.ModeDir|0o700)
+	assertMode(t, filepath.Join(dir, "ec2", "latest", "user-data"), 0o600)
+	assertMode(t, filepath.Join(dir, "ec2", "latest", "meta-data.json"), 0o600)
+}
+
+func TestWriteDirOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	userData := filepath.Join(dir, "user-data")
+	require.NoError(t, os.WriteFile(userData, []byte("#cloud-config\n"), 0o644))
+	require.NoError(t, os.Chmod(userData, 0o644))
+
+	c := cloudinit.NewConfig()
+	require.NoError(t, c.WriteDir(dir))
+
+	assertMode(t, userData, 0o600)
+}
+
+func assertMode(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, mode, info.Mode(), path)
+}
+
+func TestWriteTar(t *testing.T) {
+	c := cloudinit.NewConfigDriveConfig()
+	c.SetFQDN("tar.example.com")
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, c.WriteTar(buf))
+
+	files := make(map[string][]byte)
+	modes := make(map[string]int64)
+
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+
+		files[hdr.Name] = data
+		modes[hdr.Name] = hdr.Mode
+	}
+
+	assert.Contains(t, files, "openstack/")
+	assert.Contains(t, files, "openstack/latest/")
+	assert.Contains(t, files, "openstack/latest/meta_data.json")
+	assert.Equal(t, c.GenerateConfigContent(), files["openstack/latest/user_data"])
+	assert.NotContains(t, files, "openstack/latest/network_data.json")
+
+	assert.Equal(t, map[string]int64{
+		"openstack/":                      0o700,
+		"openstack/latest/":               0o700,
+		"openstack/latest/meta_data.json": 0o600,
+		"openstack/latest/user_data":      0o600,
+	}, modes)
+}
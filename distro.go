@@ -0,0 +1,128 @@
+package cloudinit
+
+import (
+	"maps"
+	"slices"
+)
+
+// Distro is the distribution of the guest image, using cloud-init's distro names.
+type Distro string
+
+const (
+	DistroUbuntu    Distro = "ubuntu"
+	DistroDebian    Distro = "debian"
+	DistroRHEL      Distro = "rhel"
+	DistroCentOS    Distro = "centos"
+	DistroRocky     Distro = "rocky"
+	DistroAlmaLinux Distro = "almalinux"
+	DistroFedora    Distro = "fedora"
+	DistroOpenSUSE  Distro = "opensuse"
+	DistroAlpine    Distro = "alpine"
+	DistroArch      Distro = "arch"
+)
+
+// PasswordHasher returns the hasher whose hashes every supported release of
+// the distro can verify. Yescrypt is only used where all of them ship
+// libxcrypt with yescrypt support, SHA-512 crypt everywhere else.
+func (d Distro) PasswordHasher() PasswordHasher {
+	switch d {
+	case DistroFedora, DistroArch:
+		return Yescrypt{}
+	case DistroUbuntu, DistroDebian, DistroRHEL, DistroCentOS, DistroRocky, DistroAlmaLinux,
+		DistroOpenSUSE, DistroAlpine:
+		return SHA512Crypt{}
+	default:
+		return DefaultPasswordHasher
+	}
+}
+
+// osFamily returns the cloud-init OS family of the distro, which names its templates.
+func (d Distro) osFamily() string {
+	switch d {
+	case DistroUbuntu, DistroDebian:
+		return "debian"
+	case DistroRHEL, DistroCentOS, DistroRocky, DistroAlmaLinux, DistroFedora:
+		return "redhat"
+	case DistroOpenSUSE:
+		return "suse"
+	case DistroAlpine, DistroArch:
+		return string(d)
+	default:
+		return ""
+	}
+}
+
+// InitSystem is the service manager of the guest image.
+type InitSystem string
+
+const (
+	InitSystemd InitSystem = "systemd"
+	InitOpenRC  InitSystem = "openrc"
+)
+
+// PackageManager describes how packages and services are managed on a distro.
+type PackageManager struct {
+	// Name is the package manager, e.g. "apt" or "dnf".
+	Name string
+	// InitSystem is the service manager.
+	InitSystem InitSystem
+	// GuestAgentPackage is the package of the QEMU guest agent.
+	GuestAgentPackage string
+	// GuestAgentService is the service of the QEMU guest agent.
+	GuestAgentService string
+}
+
+// EnableServiceCommand returns the runcmd command that enables and starts the service.
+func (p PackageManager) EnableServiceCommand(service string) string {
+	if p.InitSystem == InitOpenRC {
+		return "rc-update add " + service + " default && rc-service " + service + " start"
+	}
+
+	return "systemctl enable " + service + " --now"
+}
+
+// packageManagers are the package managers of the supported distros.
+//
+//nolint:gochecknoglobals // Read-only table.
+var packageManagers = map[Distro]PackageManager{
+	DistroUbuntu:    {Name: "apt", InitSystem: InitSystemd},
+	DistroDebian:    {Name: "apt", InitSystem: InitSystemd},
+	DistroRHEL:      {Name: "dnf", InitSystem: InitSystemd},
+	DistroCentOS:    {Name: "dnf", InitSystem: InitSystemd},
+	DistroRocky:     {Name: "dnf", InitSystem: InitSystemd},
+	DistroAlmaLinux: {Name: "dnf", InitSystem: InitSystemd},
+	DistroFedora:    {Name: "dnf", InitSystem: InitSystemd},
+	DistroOpenSUSE:  {Name: "zypper", InitSystem: InitSystemd},
+	DistroAlpine:    {Name: "apk", InitSystem: InitOpenRC},
+	DistroArch:      {Name: "pacman", InitSystem: InitSystemd},
+}
+
+// PackageManager returns the package manager of the distro. Unknown distros
+// are assumed to use systemd, like Debian.
+func (d Distro) PackageManager() PackageManager {
+	pm, ok := packageManagers[d]
+	if !ok {
+		pm = PackageManager{InitSystem: InitSystemd}
+	}
+
+	if pm.GuestAgentPackage == "" {
+		pm.GuestAgentPackage = guestAgentPackage
+	}
+
+	if pm.GuestAgentService == "" {
+		pm.GuestAgentService = guestAgentService
+	}
+
+	return pm
+}
+
+// knownPackageManagers returns the package managers of the supported distros
+// and the default one, in a stable order.
+func knownPackageManagers() []PackageManager {
+	pms := []PackageManager{Distro("").PackageManager()}
+	for _, d := range slices.Sorted(maps.Keys(packageManagers)) {
+		pms = append(pms, d.PackageManager())
+	}
+
+	return pms
+}
@@ -1,5 +1,8 @@
 package cloudinit
 
+// EC2VolumeName is the volume label of EC2 seed images.
+const EC2VolumeName = "ec2-seed"
+
 // EC2Metadata represents the EC2-specific metadata structure
 // For more information see: https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/instancedata-data-categories.html
 type EC2Metadata struct {
@@ -33,3 +36,19 @@ type EC2Config struct {
 	*Config
 	metadata EC2Metadata
 }
+
+func (c *Config) generateEC2Metadata() (EC2Metadata, error) {
+	var meta EC2Metadata
+	if c.ec2Meta != nil {
+		meta = *c.ec2Meta
+	}
+
+	id, err := c.InstanceID()
+	if err != nil {
+		return meta, err
+	}
+
+	meta.InstanceID = id
+
+	return meta, nil
+}
@@ -0,0 +1,852 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrUnsupportedDataSource is returned when the data source cannot be written in the requested seed format.
+var ErrUnsupportedDataSource = errors.New("data source does not support this seed format")
+
+const (
+	fatSectorSize   = 512
+	fatDirEntrySize = 32
+	fatLFNChars     = 13
+
+	fatAttrVolumeID  = 0x08
+	fatAttrDirectory = 0x10
+	fatAttrArchive   = 0x20
+	fatAttrLFN       = 0x0f
+
+	fat12MaxClusters = 4084
+	fat16MinClusters = 4085
+	fat16MaxClusters = 65524
+)
+
+// fatShortNameChars are the characters allowed in 8.3 names besides letters and digits.
+const fatShortNameChars = "$%'-_@~`!(){}^#&"
+
+// fatGeometry describes the layout of a FAT volume.
+type fatGeometry struct {
+	bits              int
+	totalSectors      uint32
+	sectorsPerCluster uint8
+	rootEntries       uint16
+	fatSectors        uint16
+	media             byte
+	sectorsPerTrack   uint16
+	heads             uint16
+	clusters          int
+}
+
+func (g fatGeometry) clusterSize() int {
+	return int(g.sectorsPerCluster) * fatSectorSize
+}
+
+func (g fatGeometry) rootDirSectors() int {
+	return (int(g.rootEntries)*fatDirEntrySize + fatSectorSize - 1) / fatSectorSize
+}
+
+func (g fatGeometry) fatOffset() int {
+	return fatSectorSize // one reserved sector
+}
+
+func (g fatGeometry) rootDirOffset() int {
+	return g.fatOffset() + 2*int(g.fatSectors)*fatSectorSize
+}
+
+func (g fatGeometry) dataOffset() int {
+	return g.rootDirOffset() + g.rootDirSectors()*fatSectorSize
+}
+
+func (g fatGeometry) clusterOffset(cluster int) int {
+	return g.dataOffset() + (cluster-2)*g.clusterSize()
+}
+
+// fatNode is a file or directory of the image being built.
+type fatNode struct {
+	name      string
+	shortName [11]byte
+	longName  bool
+	isDir     bool
+	data      []byte
+	children  []*fatNode
+	cluster   int
+}
+
+func (n *fatNode) dir(name string) *fatNode {
+	for _, child := range n.children {
+		if child.isDir && child.name == name {
+			return child
+		}
+	}
+
+	child := &fatNode{name: name, isDir: true}
+	n.children = append(n.children, child)
+
+	return child
+}
+
+// entries returns the number of directory entries the node takes in its parent.
+func (n *fatNode) entries() int {
+	if !n.longName {
+		return 1
+	}
+
+	return 1 + (len(utf16.Encode([]rune(n.name)))+fatLFNChars-1)/fatLFNChars
+}
+
+// size returns the size in bytes of the directory contents or the file data.
+func (n *fatNode) size() int {
+	if !n.isDir {
+		return len(n.data)
+	}
+
+	entries := 2 // "." and ".."
+	for _, child := range n.children {
+		entries += child.entries()
+	}
+
+	return entries * fatDirEntrySize
+}
+
+// WriteVFAT writes the cloud-init configuration to a FAT12/16 disk image with
+// the same files as WriteISO, labelled like the ISO in upper case. Only the
+// NoCloud and ConfigDrive data sources read seeds from disks. Runs of empty
+// sectors are skipped with Seek, so files stay sparse.
+func (c *Config) WriteVFAT(w io.WriteSeeker) error {
+	switch c.dataSourceType {
+	case string(DataSourceEC2), string(DataSourceGCE):
+		return fmt.Errorf("%w: %s", ErrUnsupportedDataSource, c.dataSourceType)
+	}
+
+	label, files, err := c.seedFiles()
+	if err != nil {
+		return err
+	}
+
+	img, err := buildFATImage(strings.ToUpper(label), files, c.now())
+	if err != nil {
+		return fmt.Errorf("failed to build VFAT image: %w", err)
+	}
+
+	if err := writeSparse(w, img); err != nil {
+		return fmt.Errorf("failed to write VFAT image: %w", err)
+	}
+
+	return nil
+}
+
+func buildFATImage(label string, files []seedFile, modTime time.Time) ([]byte, error) {
+	root := &fatNode{isDir: true}
+
+	for _, f := range files {
+		parts := strings.Split(f.path, "/")
+
+		dir := root
+		for _, part := range parts[:len(parts)-1] {
+			dir = dir.dir(part)
+		}
+
+		dir.children = append(dir.children, &fatNode{name: parts[len(parts)-1], data: f.data})
+	}
+
+	assignShortNames(root)
+
+	rootEntries := 1 // volume label
+	for _, child := range root.children {
+		rootEntries += child.entries()
+	}
+
+	geo, err := chooseFATGeometry(root, rootEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	img := make([]byte, int(geo.totalSectors)*fatSectorSize)
+	fat := make([]uint16, geo.clusters+2)
+	fat[0] = 0xff00 | uint16(geo.media)
+	fat[1] = 0xffff
+
+	next := 2
+	allocate(root, geo, fat, &next)
+
+	date, tod := fatTimestamp(modTime)
+
+	writeBootSector(img, geo, label, uint32(date)<<16|uint32(tod))
+
+	rootDir := img[geo.rootDirOffset():geo.dataOffset()]
+	copy(rootDir, fatDirEntry(fatLabel(label), fatAttrVolumeID, 0, 0, date, tod))
+	writeDirEntries(rootDir[fatDirEntrySize:], root.children, date, tod)
+
+	writeNodes(img, geo, root, date, tod)
+
+	for i := 0; i < 2; i++ {
+		table := img[geo.fatOffset()+i*int(geo.fatSectors)*fatSectorSize:]
+		encodeFAT(table, fat, geo.bits)
+	}
+
+	return img, nil
+}
+
+// chooseFATGeometry picks the 1.44 MB floppy layout when the files fit, and
+// a FAT16 layout with enough clusters otherwise.
+func chooseFATGeometry(root *fatNode, rootEntries int) (fatGeometry, error) {
+	floppy := fatGeometry{
+		bits:              12,
+		totalSectors:      2880,
+		sectorsPerCluster: 1,
+		rootEntries:       224,
+		fatSectors:        9,
+		media:             0xf0,
+		sectorsPerTrack:   18,
+		heads:             2,
+	}
+	floppy.clusters = (int(floppy.totalSectors)*fatSectorSize - floppy.dataOffset()) / floppy.clusterSize()
+
+	if rootEntries <= int(floppy.rootEntries) && clustersNeeded(root, floppy.clusterSize()) <= floppy.clusters {
+		return floppy, nil
+	}
+
+	rootEntryCount := max(512, (rootEntries+15)/16*16)
+	if rootEntryCount > 0xffff {
+		return fatGeometry{}, fmt.Errorf("too many files in the root directory: %d", rootEntries)
+	}
+
+	for spc := 1; spc <= 64; spc *= 2 {
+		geo := fatGeometry{
+			bits:              16,
+			sectorsPerCluster: uint8(spc),
+			rootEntries:       uint16(rootEntryCount),
+			media:             0xf8,
+			sectorsPerTrack:   32,
+			heads:             64,
+		}
+
+		needed := clustersNeeded(root, geo.clusterSize())
+		if needed > fat16MaxClusters {
+			continue
+		}
+
+		geo.clusters = max(needed, fat16MinClusters+11)
+		geo.fatSectors = uint16(((geo.clusters+2)*2 + fatSectorSize - 1) / fatSectorSize)
+		geo.totalSectors = uint32(1 + 2*int(geo.fatSectors) + geo.rootDirSectors() + geo.clusters*spc)
+
+		return geo, nil
+	}
+
+	return fatGeometry{}, errors.New("files do not fit on a FAT16 volume")
+}
+
+func clustersNeeded(n *fatNode, clusterSize int) int {
+	total := 0
+
+	for _, child := range n.children {
+		total += (child.size() + clusterSize - 1) / clusterSize
+		if child.isDir {
+			total += clustersNeeded(child, clusterSize)
+		}
+	}
+
+	return total
+}
+
+// allocate assigns contiguous cluster chains to every node below n.
+func allocate(n *fatNode, geo fatGeometry, fat []uint16, next *int) {
+	for _, child := range n.children {
+		count := (child.size() + geo.clusterSize() - 1) / geo.clusterSize()
+		if count == 0 {
+			continue
+		}
+
+		child.cluster = *next
+		for i := 0; i < count-1; i++ {
+			fat[*next+i] = uint16(*next + i + 1)
+		}
+		fat[*next+count-1] = 0xffff
+		*next += count
+	}
+
+	for _, child := range n.children {
+		if child.isDir {
+			allocate(child, geo, fat, next)
+		}
+	}
+}
+
+func writeNodes(img []byte, geo fatGeometry, n *fatNode, date, tod uint16) {
+	for _, child := range n.children {
+		if child.cluster == 0 {
+			continue
+		}
+
+		dst := img[geo.clusterOffset(child.cluster):]
+
+		if !child.isDir {
+			copy(dst, child.data)
+			continue
+		}
+
+		var dot, dotdot [11]byte
+		copy(dot[:], ".          ")
+		copy(dotdot[:], "..         ")
+
+		copy(dst, fatDirEntry(dot, fatAttrDirectory, child.cluster, 0, date, tod))
+		copy(dst[fatDirEntrySize:], fatDirEntry(dotdot, fatAttrDirectory, n.cluster, 0, date, tod))
+		writeDirEntries(dst[2*fatDirEntrySize:], child.children, date, tod)
+		writeNodes(img, geo, child, date, tod)
+	}
+}
+
+func writeDirEntries(dst []byte, children []*fatNode, date, tod uint16) {
+	off := 0
+
+	for _, child := range children {
+		if child.longName {
+			for _, entry := range fatLFNEntries(child.name, child.shortName) {
+				copy(dst[off:], entry)
+				off += fatDirEntrySize
+			}
+		}
+
+		attr := byte(fatAttrArchive)
+		size := len(child.data)
+
+		if child.isDir {
+			attr = fatAttrDirectory
+			size = 0
+		}
+
+		copy(dst[off:], fatDirEntry(child.shortName, attr, child.cluster, uint32(size), date, tod))
+		off += fatDirEntrySize
+	}
+}
+
+func fatDirEntry(name [11]byte, attr byte, cluster int, size uint32, date, tod uint16) []byte {
+	e := make([]byte, fatDirEntrySize)
+	copy(e[0:11], name[:])
+	e[11] = attr
+
+	if attr != fatAttrVolumeID {
+		binary.LittleEndian.PutUint16(e[14:], tod)
+		binary.LittleEndian.PutUint16(e[16:], date)
+		binary.LittleEndian.PutUint16(e[18:], date)
+	}
+
+	binary.LittleEndian.PutUint16(e[22:], tod)
+	binary.LittleEndian.PutUint16(e[24:], date)
+	binary.LittleEndian.PutUint16(e[26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(e[28:], size)
+
+	return e
+}
+
+// fatLFNEntries returns the long file name entries for name, in on-disk order.
+func fatLFNEntries(name string, shortName [11]byte) [][]byte {
+	var sum byte
+	for _, b := range shortName {
+		sum = (sum&1)<<7 + sum>>1 + b
+	}
+
+	chars := utf16.Encode([]rune(name))
+	count := (len(chars) + fatLFNChars - 1) / fatLFNChars
+
+	padded := make([]uint16, count*fatLFNChars)
+	copy(padded, chars)
+
+	for i := len(chars); i < len(padded); i++ {
+		if i == len(chars) {
+			padded[i] = 0x0000
+		} else {
+			padded[i] = 0xffff
+		}
+	}
+
+	// Character positions within an LFN entry.
+	offsets := [fatLFNChars]int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30}
+
+	entries := make([][]byte, 0, count)
+
+	for seq := count; seq >= 1; seq-- {
+		e := make([]byte, fatDirEntrySize)
+
+		e[0] = byte(seq)
+		if seq == count {
+			e[0] |= 0x40
+		}
+
+		e[11] = fatAttrLFN
+		e[13] = sum
+
+		for i, off := range offsets {
+			binary.LittleEndian.PutUint16(e[off:], padded[(seq-1)*fatLFNChars+i])
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// assignShortNames gives every node an unique 8.3 name within its directory,
+// and marks the nodes that need a long file name to keep their name.
+func assignShortNames(dir *fatNode) {
+	sort.SliceStable(dir.children, func(i, j int) bool {
+		return dir.children[i].name < dir.children[j].name
+	})
+
+	used := make(map[[11]byte]bool)
+
+	for _, child := range dir.children {
+		child.shortName, child.longName = fatShortName(child.name, used)
+		used[child.shortName] = true
+
+		if child.isDir {
+			assignShortNames(child)
+		}
+	}
+}
+
+func fatShortName(name string, used map[[11]byte]bool) ([11]byte, bool) {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+
+	cleanBase, lossyBase := fatCleanName(base)
+	cleanExt, lossyExt := fatCleanName(ext)
+
+	var short [11]byte
+	copy(short[:], strings.Repeat(" ", 11))
+
+	if !lossyBase && !lossyExt && len(cleanBase) <= 8 && len(cleanExt) <= 3 {
+		copy(short[:8], cleanBase)
+		copy(short[8:], cleanExt)
+
+		if !used[short] {
+			return short, cleanBase != base || cleanExt != ext
+		}
+	}
+
+	if len(cleanExt) > 3 {
+		cleanExt = cleanExt[:3]
+	}
+
+	for i := 1; ; i++ {
+		suffix := fmt.Sprintf("~%d", i)
+		prefix := cleanBase
+		if len(prefix) > 8-len(suffix) {
+			prefix = prefix[:8-len(suffix)]
+		}
+
+		copy(short[:], strings.Repeat(" ", 11))
+		copy(short[:8], prefix+suffix)
+		copy(short[8:], cleanExt)
+
+		if !used[short] {
+			return short, true
+		}
+	}
+}
+
+// fatCleanName upper-cases s and replaces the characters not allowed in 8.3
+// names. It reports whether information was lost besides the case.
+func fatCleanName(s string) (string, bool) {
+	var b strings.Builder
+
+	lossy := false
+
+	for _, r := range strings.ToUpper(s) {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(fatShortNameChars, r):
+			b.WriteRune(r)
+		case r == ' ' || r == '.':
+			lossy = true
+		default:
+			b.WriteRune('_')
+			lossy = true
+		}
+	}
+
+	return b.String(), lossy
+}
+
+func fatLabel(label string) [11]byte {
+	var l [11]byte
+	copy(l[:], strings.Repeat(" ", 11))
+	copy(l[:], label)
+
+	return l
+}
+
+// fatTimestamp converts t to the DOS date and time format.
+func fatTimestamp(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	date := uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	tod := uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+
+	return date, tod
+}
+
+func writeBootSector(img []byte, geo fatGeometry, label string, volumeID uint32) {
+	bs := img[:fatSectorSize]
+
+	copy(bs[0:], []byte{0xeb, 0x3c, 0x90})
+	copy(bs[3:11], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(bs[11:], fatSectorSize)
+	bs[13] = geo.sectorsPerCluster
+	binary.LittleEndian.PutUint16(bs[14:], 1) // reserved sectors
+	bs[16] = 2                                // number of FATs
+	binary.LittleEndian.PutUint16(bs[17:], geo.rootEntries)
+
+	if geo.totalSectors < 0x10000 {
+		binary.LittleEndian.PutUint16(bs[19:], uint16(geo.totalSectors))
+	} else {
+		binary.LittleEndian.PutUint32(bs[32:], geo.totalSectors)
+	}
+
+	bs[21] = geo.media
+	binary.LittleEndian.PutUint16(bs[22:], geo.fatSectors)
+	binary.LittleEndian.PutUint16(bs[24:], geo.sectorsPerTrack)
+	binary.LittleEndian.PutUint16(bs[26:], geo.heads)
+
+	if geo.media != 0xf0 {
+		bs[36] = 0x80 // fixed disk
+	}
+
+	bs[38] = 0x29 // extended boot signature
+	binary.LittleEndian.PutUint32(bs[39:], volumeID)
+
+	l := fatLabel(label)
+	copy(bs[43:54], l[:])
+	copy(bs[54:62], fmt.Sprintf("FAT%d   ", geo.bits))
+
+	bs[510], bs[511] = 0x55, 0xaa
+}
+
+// encodeFAT writes the cluster table in the 12 or 16 bit on-disk format.
+// Entries hold 16 bit values, which are truncated for FAT12.
+func encodeFAT(dst []byte, fat []uint16, bits int) {
+	if bits == 16 {
+		for i, v := range fat {
+			binary.LittleEndian.PutUint16(dst[2*i:], v)
+		}
+
+		return
+	}
+
+	for i, v := range fat {
+		v &= 0x0fff
+		off := i * 3 / 2
+
+		if i%2 == 0 {
+			dst[off] = byte(v)
+			dst[off+1] = dst[off+1]&0xf0 | byte(v>>8)
+		} else {
+			dst[off] = dst[off]&0x0f | byte(v<<4)
+			dst[off+1] = byte(v >> 4)
+		}
+	}
+}
+
+// writeSparse writes img to w, seeking over runs of empty sectors.
+func writeSparse(w io.WriteSeeker, img []byte) error {
+	zero := make([]byte, fatSectorSize)
+	skip := int64(0)
+
+	for off := 0; off < len(img); off += fatSectorSize {
+		sector := img[off : off+fatSectorSize]
+		if bytes.Equal(sector, zero) {
+			skip += fatSectorSize
+			continue
+		}
+
+		if skip > 0 {
+			if _, err := w.Seek(skip, io.SeekCurrent); err != nil {
+				return err
+			}
+			skip = 0
+		}
+
+		if _, err := w.Write(sector); err != nil {
+			return err
+		}
+	}
+
+	if skip > 0 {
+		// Write the last byte, so the output has the full size.
+		if _, err := w.Seek(skip-1, io.SeekCurrent); err != nil {
+			return err
+		}
+
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fatVolume reads files from a FAT12/16 image.
+type fatVolume struct {
+	r     io.ReaderAt
+	geo   fatGeometry
+	fat   []byte
+	label string
+}
+
+func openFATVolume(r io.ReaderAt) (*fatVolume, error) {
+	bs := make([]byte, fatSectorSize)
+	if _, err := r.ReadAt(bs, 0); err != nil {
+		return nil, fmt.Errorf("failed to read boot sector: %w", err)
+	}
+
+	if bs[510] != 0x55 || bs[511] != 0xaa || binary.LittleEndian.Uint16(bs[11:]) != fatSectorSize {
+		return nil, errors.New("not a FAT image")
+	}
+
+	geo := fatGeometry{
+		sectorsPerCluster: bs[13],
+		rootEntries:       binary.LittleEndian.Uint16(bs[17:]),
+		totalSectors:      uint32(binary.LittleEndian.Uint16(bs[19:])),
+		media:             bs[21],
+		fatSectors:        binary.LittleEndian.Uint16(bs[22:]),
+	}
+
+	if geo.totalSectors == 0 {
+		geo.totalSectors = binary.LittleEndian.Uint32(bs[32:])
+	}
+
+	reserved := int(binary.LittleEndian.Uint16(bs[14:]))
+	if reserved != 1 || bs[16] != 2 || geo.sectorsPerCluster == 0 || geo.fatSectors == 0 {
+		return nil, errors.New("unsupported FAT layout")
+	}
+
+	geo.clusters = (int(geo.totalSectors)*fatSectorSize - geo.dataOffset()) / geo.clusterSize()
+
+	switch {
+	case geo.clusters <= fat12MaxClusters:
+		geo.bits = 12
+	case geo.clusters <= fat16MaxClusters:
+		geo.bits = 16
+	default:
+		return nil, errors.New("FAT32 images are not supported")
+	}
+
+	v := &fatVolume{
+		r:     r,
+		geo:   geo,
+		fat:   make([]byte, int(geo.fatSectors)*fatSectorSize),
+		label: strings.TrimSpace(string(bs[43:54])),
+	}
+
+	if _, err := r.ReadAt(v.fat, int64(geo.fatOffset())); err != nil {
+		return nil, fmt.Errorf("failed to read FAT: %w", err)
+	}
+
+	root := make([]byte, geo.rootDirSectors()*fatSectorSize)
+	if _, err := r.ReadAt(root, int64(geo.rootDirOffset())); err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %w", err)
+	}
+
+	// The label in the root directory takes precedence, like in blkid.
+	for off := 0; off+fatDirEntrySize <= len(root) && root[off] != 0; off += fatDirEntrySize {
+		if root[off] != 0xe5 && root[off+11]&0x3f == fatAttrVolumeID {
+			v.label = strings.TrimSpace(string(root[off : off+11]))
+			break
+		}
+	}
+
+	return v, nil
+}
+
+// next returns the cluster following cluster in its chain, or 0 at the end.
+func (v *fatVolume) next(cluster int) int {
+	var value int
+
+	if v.geo.bits == 16 {
+		value = int(binary.LittleEndian.Uint16(v.fat[2*cluster:]))
+		if value >= 0xfff8 {
+			return 0
+		}
+	} else {
+		off := cluster * 3 / 2
+		pair := int(binary.LittleEndian.Uint16(v.fat[off:]))
+
+		if cluster%2 == 0 {
+			value = pair & 0x0fff
+		} else {
+			value = pair >> 4
+		}
+
+		if value >= 0xff8 {
+			return 0
+		}
+	}
+
+	if value < 2 || value >= v.geo.clusters+2 {
+		return 0
+	}
+
+	return value
+}
+
+func (v *fatVolume) readChain(cluster int) ([]byte, error) {
+	var data []byte
+
+	buf := make([]byte, v.geo.clusterSize())
+
+	for seen := 0; cluster != 0; seen++ {
+		if seen > v.geo.clusters {
+			return nil, errors.New("cluster chain loops")
+		}
+
+		if _, err := v.r.ReadAt(buf, int64(v.geo.clusterOffset(cluster))); err != nil {
+			return nil, err
+		}
+
+		data = append(data, buf...)
+		cluster = v.next(cluster)
+	}
+
+	return data, nil
+}
+
+type fatDirEntryInfo struct {
+	name    string
+	isDir   bool
+	cluster int
+	size    int
+}
+
+// parseDir decodes the entries of a directory, resolving long file names.
+func parseDir(data []byte) []fatDirEntryInfo {
+	var (
+		entries []fatDirEntryInfo
+		lfn     []uint16
+	)
+
+	for off := 0; off+fatDirEntrySize <= len(data); off += fatDirEntrySize {
+		e := data[off : off+fatDirEntrySize]
+		if e[0] == 0 {
+			break
+		}
+
+		if e[0] == 0xe5 {
+			lfn = nil
+			continue
+		}
+
+		if e[11] == fatAttrLFN {
+			var part []uint16
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part = append(part, binary.LittleEndian.Uint16(e[o:]))
+			}
+
+			// Entries are stored last part first.
+			lfn = append(part, lfn...)
+
+			continue
+		}
+
+		if e[11]&fatAttrVolumeID != 0 {
+			lfn = nil
+			continue
+		}
+
+		name := fatShortNameString(e[0:11])
+
+		if lfn != nil {
+			end := len(lfn)
+			for i, ch := range lfn {
+				if ch == 0 {
+					end = i
+					break
+				}
+			}
+
+			name = string(utf16.Decode(lfn[:end]))
+			lfn = nil
+		}
+
+		entries = append(entries, fatDirEntryInfo{
+			name:    name,
+			isDir:   e[11]&fatAttrDirectory != 0,
+			cluster: int(binary.LittleEndian.Uint16(e[26:])),
+			size:    int(binary.LittleEndian.Uint32(e[28:])),
+		})
+	}
+
+	return entries
+}
+
+func fatShortNameString(name []byte) string {
+	base := strings.TrimRight(string(name[0:8]), " ")
+	ext := strings.TrimRight(string(name[8:11]), " ")
+
+	if ext == "" {
+		return base
+	}
+
+	return base + "." + ext
+}
+
+func (v *fatVolume) readFile(filePath string) ([]byte, error) {
+	dir := make([]byte, v.geo.rootDirSectors()*fatSectorSize)
+	if _, err := v.r.ReadAt(dir, int64(v.geo.rootDirOffset())); err != nil {
+		return nil, fmt.Errorf("failed to read root directory: %w", err)
+	}
+
+	parts := strings.Split(filePath, "/")
+
+	for i, name := range parts {
+		var found *fatDirEntryInfo
+
+		for _, e := range parseDir(dir) {
+			if strings.EqualFold(e.name, name) {
+				found = &e
+				break
+			}
+		}
+
+		if found == nil {
+			return nil, &seedFileNotFoundError{path: filePath}
+		}
+
+		data, err := v.readChain(found.cluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+
+		if i == len(parts)-1 {
+			if found.isDir {
+				return nil, fmt.Errorf("%s is a directory", filePath)
+			}
+
+			if found.size > len(data) {
+				return nil, fmt.Errorf("failed to read %s: truncated cluster chain", filePath)
+			}
+
+			return data[:found.size], nil
+		}
+
+		if !found.isDir {
+			return nil, &seedFileNotFoundError{path: filePath}
+		}
+
+		dir = data
+	}
+
+	return nil, &seedFileNotFoundError{path: filePath}
+}
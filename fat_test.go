@@ -0,0 +1,63 @@
+package cloudinit_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestWriteVFAT(t *testing.T) {
+	testCases := []struct {
+		name    string
+		newFunc func() *cloudinit.Config
+		label   string
+		runcmd  string
+	}{
+		{name: "NoCloud", newFunc: cloudinit.NewConfig, label: "CIDATA", runcmd: "echo hello"},
+		{name: "ConfigDrive", newFunc: cloudinit.NewConfigDriveConfig, label: "CONFIG-2", runcmd: "echo hello"},
+		// Does not fit on a floppy sized FAT12 image.
+		{name: "FAT16", newFunc: cloudinit.NewConfig, label: "CIDATA", runcmd: strings.Repeat("x", 2<<20)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.newFunc()
+			c.SetFQDN("vfat.example.com")
+			c.SetCloudConfig(&cloudinit.CloudConfig{RunCommands: []cloudinit.Command{cloudinit.ShellCommand(tc.runcmd)}})
+			c.SetStaticInterfaceAddress("00:11:22:33:44:55", "192.168.1.100/24", "192.168.1.1", "8.8.8.8")
+
+			f, err := os.CreateTemp("", "seed-*.img")
+			require.NoError(t, err)
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			require.NoError(t, c.WriteVFAT(f))
+
+			seed, err := cloudinit.OpenSeed(f)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.label, seed.Label)
+			assert.Equal(t, c.GenerateConfigContent(), seed.UserData)
+			assert.NotEmpty(t, seed.MetaData)
+			assert.NotEmpty(t, seed.NetworkConfig)
+
+			restored, err := seed.Config()
+			require.NoError(t, err)
+			assert.Equal(t, c.GenerateConfigContent(), restored.GenerateConfigContent())
+		})
+	}
+}
+
+func TestWriteVFATUnsupportedDataSource(t *testing.T) {
+	f, err := os.CreateTemp("", "seed-*.img")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = cloudinit.NewEC2Config().WriteVFAT(f)
+	require.ErrorIs(t, err, cloudinit.ErrUnsupportedDataSource)
+}
@@ -1,15 +1,13 @@
 package cloudinit
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"io"
 	"time"
-
-	"github.com/kdomanski/iso9660"
 )
 
+// GCEVolumeName is the volume label of GCE seed images.
+const GCEVolumeName = "google-compute-engine"
+
 // GCEMetadata represents Google Compute Engine instance metadata
 // For more information see: https://cloud.google.com/compute/docs/metadata/overview
 type GCEMetadata struct {
@@ -105,7 +103,7 @@ func (c *Config) SetGCEMetadata(instanceName, zone, projectID string) {
 	c.gceMetadata.Instance.Name = instanceName
 	c.gceMetadata.Instance.Zone = zone
 	c.gceMetadata.Project.ProjectID = projectID
-	c.gceMetadata.Instance.CreatedAt = time.Now().UTC()
+	c.gceMetadata.Instance.CreatedAt = c.now().UTC()
 }
 
 func (c *Config) AddGCELabel(key, value string) {
@@ -115,46 +113,6 @@ func (c *Config) AddGCELabel(key, value string) {
 	c.gceMetadata.Instance.Labels[key] = value
 }
 
-func (c *Config) writeGCEISO(w io.Writer) error {
-	writer, err := iso9660.NewWriter()
-	if err != nil {
-		return fmt.Errorf("failed to create ISO writer: %w", err)
-	}
-	defer writer.Cleanup()
-
-	// GCE metadata structure
-	metadataJSON, err := json.Marshal(c.gceMetadata)
-	if err != nil {
-		return fmt.Errorf("failed to marshal GCE metadata: %w", err)
-	}
-
-	// GCE expects files in a specific structure
-	if err := writer.AddFile(bytes.NewReader(metadataJSON),
-		"computeMetadata/v1/instance/attributes.json"); err != nil {
-		return fmt.Errorf("failed to add GCE metadata: %w", err)
-	}
-
-	if err := writer.AddFile(bytes.NewReader(c.GenerateConfigContent()),
-		"user-data"); err != nil {
-		return fmt.Errorf("failed to add user-data: %w", err)
-	}
-
-	// Add network configuration if present
-	if len(c.networkInterfaces) > 0 {
-		networkConfig := c.generateGCENetworkConfig()
-		if err := writer.AddFile(bytes.NewReader(networkConfig),
-			"network-config"); err != nil {
-			return fmt.Errorf("failed to add network-config: %w", err)
-		}
-	}
-
-	if err := writer.WriteTo(w, "google-compute-engine"); err != nil {
-		return fmt.Errorf("failed to write GCE ISO image: %w", err)
-	}
-
-	return nil
-}
-
 func (c *Config) generateGCENetworkConfig() []byte {
 	// GCE network configuration format
 	type gceNetwork struct {
@@ -188,7 +146,9 @@ func (c *Config) generateGCENetworkConfig() []byte {
 	}
 
 	// Convert our network interfaces to GCE format
-	for mac, iface := range c.networkInterfaces {
+	for _, mac := range c.interfaceMACs() {
+		iface := c.networkInterfaces[mac]
+
 		netConfig := struct {
 			Type       string `json:"type"`
 			Name       string `json:"name"`
@@ -224,3 +184,19 @@ func (c *Config) generateGCENetworkConfig() []byte {
 	data, _ := json.Marshal(net)
 	return data
 }
+
+func (c *Config) generateGCEMetadata() (GCEMetadata, error) {
+	var meta GCEMetadata
+	if c.gceMetadata != nil {
+		meta = *c.gceMetadata
+	}
+
+	id, err := c.InstanceID()
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Instance.ID = id
+
+	return meta, nil
+}
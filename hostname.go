@@ -0,0 +1,153 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// jinjaTemplateHeader marks cloud-init templates rendered with Jinja.
+const jinjaTemplateHeader = "## template:jinja\n"
+
+// ErrInvalidHostname is returned for invalid hostname settings.
+var ErrInvalidHostname = errors.New("invalid hostname configuration")
+
+// EtcHostsMode is the manage_etc_hosts setting of the update_etc_hosts module.
+type EtcHostsMode string
+
+const (
+	// EtcHostsUnmanaged leaves /etc/hosts untouched, the cloud-init default.
+	EtcHostsUnmanaged EtcHostsMode = ""
+	// EtcHostsTemplate renders /etc/hosts from the template of the distro on every boot.
+	EtcHostsTemplate EtcHostsMode = "template"
+	// EtcHostsLocalhost adds a line resolving the hostname to 127.0.1.1, or
+	// 127.0.0.1 on some distros, keeping the rest of /etc/hosts.
+	EtcHostsLocalhost EtcHostsMode = "localhost"
+)
+
+// MarshalYAML writes true for EtcHostsTemplate, as the "template" value is deprecated.
+func (m EtcHostsMode) MarshalYAML() (interface{}, error) {
+	if m == EtcHostsTemplate {
+		return true, nil
+	}
+
+	return string(m), nil
+}
+
+// UnmarshalYAML accepts a boolean, "template" or "localhost".
+func (m *EtcHostsMode) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Value {
+	case "true", "template":
+		*m = EtcHostsTemplate
+	case "false":
+		*m = EtcHostsUnmanaged
+	case "localhost":
+		*m = EtcHostsLocalhost
+	default:
+		return fmt.Errorf("line %d: invalid manage_etc_hosts %q", node.Line, node.Value)
+	}
+
+	return nil
+}
+
+// HostnameConfig holds the settings of the set_hostname, update_hostname and
+// update_etc_hosts modules. The hostname and the FQDN are set with SetFQDN.
+type HostnameConfig struct {
+	// PreferFQDN sets the FQDN instead of the short hostname as the system hostname.
+	PreferFQDN bool
+	// ManageEtcHosts selects how cloud-init updates /etc/hosts.
+	ManageEtcHosts EtcHostsMode
+	// PreserveHostname keeps a hostname changed on the guest after the first boot.
+	PreserveHostname bool
+	// HostsTemplate replaces the /etc/hosts template of the distro. It is a
+	// Jinja template with the hostname and fqdn variables, and requires
+	// EtcHostsTemplate and a distro set with SetDistro.
+	HostsTemplate string
+}
+
+// SetHostnameConfig renders the hostname, fqdn and /etc/hosts keys of the
+// FQDN in the generated cloud-config.
+func (c *Config) SetHostnameConfig(h HostnameConfig) error {
+	if h.HostsTemplate != "" {
+		if h.ManageEtcHosts != EtcHostsTemplate {
+			return fmt.Errorf("%w: hosts template requires manage_etc_hosts: true", ErrInvalidHostname)
+		}
+
+		if c.distro.osFamily() == "" {
+			return fmt.Errorf("%w: hosts template requires a known distro", ErrInvalidHostname)
+		}
+
+		if !strings.HasPrefix(h.HostsTemplate, jinjaTemplateHeader) {
+			h.HostsTemplate = jinjaTemplateHeader + h.HostsTemplate
+		}
+	}
+
+	c.hostname = &h
+
+	return nil
+}
+
+// Hostname returns the short hostname of the FQDN.
+func (c *Config) Hostname() string {
+	hostname, _ := splitFQDN(c.fqdn)
+	return hostname
+}
+
+// Domain returns the domain of the FQDN, empty for single-label names.
+func (c *Config) Domain() string {
+	_, domain := splitFQDN(c.fqdn)
+	return domain
+}
+
+// splitFQDN splits the FQDN into the hostname and the domain. A trailing dot
+// of an absolute name is ignored.
+func splitFQDN(fqdn string) (hostname, domain string) {
+	hostname, domain, _ = strings.Cut(strings.TrimSuffix(fqdn, "."), ".")
+	return hostname, domain
+}
+
+// hostsTemplatePath returns the path of the /etc/hosts template of the distro.
+func (d Distro) hostsTemplatePath() string {
+	return "/etc/cloud/templates/hosts." + d.osFamily() + ".tmpl"
+}
+
+// applyHostname writes the hostname keys and the /etc/hosts template.
+func (c *Config) applyHostname(cc *CloudConfig) {
+	if c.hostname == nil {
+		return
+	}
+
+	cc.Hostname = c.Hostname()
+	cc.FQDN = strings.TrimSuffix(c.fqdn, ".")
+	cc.PreferFQDNOverHostname = c.hostname.PreferFQDN
+	cc.ManageEtcHosts = c.hostname.ManageEtcHosts
+	cc.PreserveHostname = c.hostname.PreserveHostname
+
+	if c.hostname.HostsTemplate != "" {
+		cc.WriteFiles = append(cc.WriteFiles, WriteFile{
+			Path:        c.distro.hostsTemplatePath(),
+			Content:     c.hostname.HostsTemplate,
+			Permissions: "0644",
+		})
+	}
+}
+
+// extractHostname moves the keys written by applyHostname back into Config.
+// The FQDN is taken from the metadata, so keys of another FQDN are kept. The
+// distro is unknown when reading a seed, so a hosts template stays in
+// write_files, which applyHostname runs early for.
+func (c *Config) extractHostname(cc *CloudConfig) {
+	if cc.FQDN == "" || cc.FQDN != strings.TrimSuffix(c.fqdn, ".") || cc.Hostname != c.Hostname() {
+		return
+	}
+
+	c.hostname = &HostnameConfig{
+		PreferFQDN:       cc.PreferFQDNOverHostname,
+		ManageEtcHosts:   cc.ManageEtcHosts,
+		PreserveHostname: cc.PreserveHostname,
+	}
+	cc.Hostname, cc.FQDN = "", ""
+	cc.PreferFQDNOverHostname, cc.ManageEtcHosts, cc.PreserveHostname = false, EtcHostsUnmanaged, false
+}
@@ -0,0 +1,57 @@
+package cloudinit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHostname(t *testing.T) {
+	for fqdn, want := range map[string][2]string{
+		"web1.example.com":  {"web1", "example.com"},
+		"web1.example.com.": {"web1", "example.com"},
+		"web1":              {"web1", ""},
+	} {
+		c := cloudinit.NewConfig()
+		c.SetFQDN(fqdn)
+		assert.Equal(t, want[0], c.Hostname(), fqdn)
+		assert.Equal(t, want[1], c.Domain(), fqdn)
+	}
+}
+
+func TestHostnameConfig(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetFQDN("web1.example.com")
+
+	require.ErrorIs(t, c.SetHostnameConfig(cloudinit.HostnameConfig{HostsTemplate: "x"}), cloudinit.ErrInvalidHostname)
+	require.ErrorIs(t, c.SetHostnameConfig(cloudinit.HostnameConfig{
+		ManageEtcHosts: cloudinit.EtcHostsTemplate,
+		HostsTemplate:  "127.0.0.1 {{fqdn}} {{hostname}}\n",
+	}), cloudinit.ErrInvalidHostname, "distro is required")
+
+	c.SetDistro(cloudinit.DistroRocky)
+	require.NoError(t, c.SetHostnameConfig(cloudinit.HostnameConfig{
+		PreferFQDN:     true,
+		ManageEtcHosts: cloudinit.EtcHostsTemplate,
+		HostsTemplate:  "127.0.0.1 {{fqdn}} {{hostname}}\n",
+	}))
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), "hostname: web1\nfqdn: web1.example.com\nprefer_fqdn_over_hostname: true\nmanage_etc_hosts: true\n")
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, cloudinit.EtcHostsTemplate, cc.ManageEtcHosts)
+	assert.Equal(t, []cloudinit.WriteFile{{
+		Path:        "/etc/cloud/templates/hosts.redhat.tmpl",
+		Content:     "## template:jinja\n127.0.0.1 {{fqdn}} {{hostname}}\n",
+		Permissions: "0644",
+	}}, cc.WriteFiles)
+
+	require.NoError(t, yaml.Unmarshal([]byte("manage_etc_hosts: localhost"), &cc))
+	assert.Equal(t, cloudinit.EtcHostsLocalhost, cc.ManageEtcHosts)
+	require.Error(t, yaml.Unmarshal([]byte("manage_etc_hosts: sometimes"), &cc))
+}
@@ -0,0 +1,76 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrInvalidInstanceID is returned for instance IDs cloud-init can not use.
+var ErrInvalidInstanceID = errors.New("invalid instance ID")
+
+// InstanceID returns the instance ID written to the metadata of every data
+// source. cloud-init runs the per-instance modules again when it changes.
+// Unless set, it is a random UUID read from the random source on first use,
+// and kept for the lifetime of the Config. The seed writers report the error
+// of reading the random source, so an empty instance ID is never written.
+func (c *Config) InstanceID() (string, error) {
+	if c.instanceID == "" {
+		id, err := newUUID(c.random)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate instance ID: %w", err)
+		}
+
+		c.instanceID = id
+	}
+
+	return c.instanceID, nil
+}
+
+// SetInstanceID sets the instance ID, a random UUID by default. It is used
+// as a directory name in /var/lib/cloud/instances, so it must not contain
+// slashes or whitespace.
+func (c *Config) SetInstanceID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/ \t\r\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidInstanceID, id)
+	}
+
+	c.instanceID = id
+
+	return nil
+}
+
+// RotateInstanceID replaces the instance ID with a new random UUID, which
+// makes cloud-init provision the guest again on the next boot.
+func (c *Config) RotateInstanceID() (string, error) {
+	id, err := newUUID(c.random)
+	if err != nil {
+		return "", err
+	}
+
+	c.instanceID = id
+
+	return id, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID(random io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(random, b); err != nil {
+		return "", fmt.Errorf("failed to generate UUID: %w", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// setInstanceIDFromMetadata keeps the instance ID of a seed, so a restored
+// Config does not re-provision the guest.
+func (c *Config) setInstanceIDFromMetadata(id string) {
+	if id != "" {
+		c.instanceID = id
+	}
+}
@@ -0,0 +1,83 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestInstanceID(t *testing.T) {
+	c := cloudinit.NewConfig()
+	id, err := c.InstanceID()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+	assertInstanceID(t, id, c)
+
+	c.SetFQDN("renamed.example.com")
+	assert.Contains(t, string(c.GenerateMetadataContent()), "instance-id: "+id+"\n")
+
+	rotated, err := c.RotateInstanceID()
+	require.NoError(t, err)
+	assert.NotEqual(t, id, rotated)
+	assertInstanceID(t, rotated, c)
+
+	require.NoError(t, c.SetInstanceID("iid-web1-0001"))
+	assertInstanceID(t, "iid-web1-0001", c)
+
+	for _, invalid := range []string{"", "..", "a/b", "a b"} {
+		require.ErrorIs(t, c.SetInstanceID(invalid), cloudinit.ErrInvalidInstanceID, invalid)
+	}
+
+	ec2 := cloudinit.NewEC2Config()
+	require.ErrorIs(t, ec2.SetEC2Metadata("i-123/456", "us-east-1a", nil), cloudinit.ErrInvalidInstanceID)
+
+	configDrive := cloudinit.NewConfigDriveConfig()
+	require.ErrorIs(t, configDrive.SetConfigDriveMetadata("uuid with spaces", "nova", nil), cloudinit.ErrInvalidInstanceID)
+}
+
+func TestInstanceIDRandomError(t *testing.T) {
+	for _, newFunc := range []func() *cloudinit.Config{
+		cloudinit.NewConfig, cloudinit.NewEC2Config, cloudinit.NewGCEConfig, cloudinit.NewConfigDriveConfig,
+	} {
+		c := newFunc()
+		c.SetRandom(bytes.NewReader(make([]byte, 8)))
+
+		require.Error(t, c.WriteISO(new(bytes.Buffer)))
+		require.Error(t, c.WriteTar(new(bytes.Buffer)))
+		assert.Nil(t, c.GenerateMetadataContent())
+
+		_, err := c.InstanceID()
+		require.Error(t, err)
+	}
+}
+
+func assertInstanceID(t *testing.T, expected string, c *cloudinit.Config) {
+	t.Helper()
+
+	id, err := c.InstanceID()
+	require.NoError(t, err)
+	assert.Equal(t, expected, id)
+}
+
+func TestInstanceIDDataSources(t *testing.T) {
+	for _, newFunc := range []func() *cloudinit.Config{
+		cloudinit.NewConfig, cloudinit.NewEC2Config, cloudinit.NewGCEConfig, cloudinit.NewConfigDriveConfig,
+	} {
+		c := newFunc()
+		require.NoError(t, c.SetInstanceID("iid-web1-0001"))
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, c.WriteISO(buf))
+
+		seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Contains(t, string(seed.MetaData), "iid-web1-0001", seed.DataSource)
+
+		restored, err := seed.Config()
+		require.NoError(t, err)
+		assertInstanceID(t, "iid-web1-0001", restored)
+	}
+}
@@ -0,0 +1,103 @@
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// Offsets in the primary volume descriptor, see ECMA-119 8.4.
+const (
+	pvdOffset              = 16 * 2048
+	pvdSystemIdentifier    = 8
+	pvdSystemIdentifierLen = 32
+	pvdCreationDate        = 813
+	pvdModificationDate    = 830
+	pvdEffectiveDate       = 864
+)
+
+// reproducibleSystemIdentifier replaces the host OS the ISO writer records,
+// so images built on different platforms are identical.
+const reproducibleSystemIdentifier = "LINUX"
+
+// SourceDateEpoch returns the time set in the SOURCE_DATE_EPOCH environment
+// variable, and false if it is not set.
+// For more information see: https://reproducible-builds.org/specs/source-date-epoch/
+func SourceDateEpoch() (time.Time, bool, error) {
+	v, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || v == "" {
+		return time.Time{}, false, nil
+	}
+
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", v, err)
+	}
+
+	return time.Unix(sec, 0).UTC(), true, nil
+}
+
+// WriteISO writes the cloud-init configuration to an ISO image.
+func (c *Config) WriteISO(w io.Writer) error {
+	label, files, err := c.seedFiles()
+	if err != nil {
+		return err
+	}
+
+	writer, err := iso9660.NewWriter()
+	if err != nil {
+		return fmt.Errorf("failed to create ISO writer: %w", err)
+	}
+	defer writer.Cleanup()
+
+	for _, f := range files {
+		if err := writer.AddFile(bytes.NewReader(f.data), f.path); err != nil {
+			return fmt.Errorf("failed to add %s: %w", f.path, err)
+		}
+	}
+
+	if err := c.writeISOImage(writer, w, label); err != nil {
+		return fmt.Errorf("failed to write ISO image: %w", err)
+	}
+
+	return nil
+}
+
+// writeISOImage writes the staged files as an ISO image. When a source date
+// is set, the volume descriptor timestamps and the system identifier are
+// fixed, so the same configuration always produces the same bytes.
+func (c *Config) writeISOImage(writer *iso9660.ImageWriter, w io.Writer, volumeIdentifier string) error {
+	if c.sourceDate == nil {
+		return writer.WriteTo(w, volumeIdentifier)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writer.WriteTo(buf, volumeIdentifier); err != nil {
+		return err
+	}
+
+	img := buf.Bytes()
+	pvd := img[pvdOffset : pvdOffset+2048]
+
+	copy(pvd[pvdSystemIdentifier:pvdSystemIdentifier+pvdSystemIdentifierLen],
+		iso9660.MarshalString(reproducibleSystemIdentifier, pvdSystemIdentifierLen))
+
+	ts := iso9660.VolumeDescriptorTimestampFromTime(*c.sourceDate)
+
+	date, err := ts.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to encode volume timestamp: %w", err)
+	}
+
+	for _, offset := range []int{pvdCreationDate, pvdModificationDate, pvdEffectiveDate} {
+		copy(pvd[offset:offset+len(date)], date)
+	}
+
+	_, err = w.Write(img)
+	return err
+}
@@ -0,0 +1,79 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"fmt"
+	mathrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/kdomanski/iso9660"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestReproducibleISO(t *testing.T) {
+	sourceDate := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	build := func(newFunc func() *cloudinit.Config) []byte {
+		c := newFunc()
+		c.SetSourceDate(sourceDate)
+		c.SetRandom(mathrand.New(mathrand.NewSource(42))) //nolint:gosec // Deterministic on purpose.
+		c.SetFQDN("repro.example.com")
+		c.SetGCEMetadata("repro", "us-central1-a", "test-project")
+		c.AddUser(cloudinit.User{Name: "admin", Password: "secret"})
+
+		for i, mac := range []string{"00:11:22:33:44:03", "00:11:22:33:44:01", "00:11:22:33:44:02"} {
+			c.SetStaticInterfaceAddress(mac, fmt.Sprintf("10.0.0.%d/24", i+1), "10.0.0.254", "10.0.0.253")
+		}
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, c.WriteISO(buf))
+
+		return buf.Bytes()
+	}
+
+	for name, newFunc := range map[string]func() *cloudinit.Config{
+		"NoCloud":     cloudinit.NewConfig,
+		"EC2":         cloudinit.NewEC2Config,
+		"GCE":         cloudinit.NewGCEConfig,
+		"ConfigDrive": cloudinit.NewConfigDriveConfig,
+	} {
+		t.Run(name, func(t *testing.T) {
+			first := build(newFunc)
+			second := build(newFunc)
+
+			assert.True(t, bytes.Equal(first, second), "images differ")
+		})
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1714564800")
+
+	ts, ok, err := cloudinit.SourceDateEpoch()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ts)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+
+	_, _, err = cloudinit.SourceDateEpoch()
+	require.Error(t, err)
+}
+
+func TestSourceDateVolumeLabel(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetSourceDate(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, c.WriteISO(buf))
+
+	img, err := iso9660.OpenImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	label, err := img.Label()
+	require.NoError(t, err)
+	assert.Equal(t, "cidata", label)
+}
@@ -0,0 +1,487 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidKubernetesNode is returned for invalid Kubernetes node profiles.
+var ErrInvalidKubernetesNode = errors.New("invalid Kubernetes node")
+
+const (
+	kubernetesModulesPath = "/etc/modules-load.d/kubernetes.conf"
+	kubernetesSysctlPath  = "/etc/sysctl.d/99-kubernetes.conf"
+	kubeadmJoinPath       = "/etc/kubernetes/kubeadm-join.yaml"
+	containerdConfigPath  = "/etc/containerd/config.toml"
+	kubernetesAptKeyring  = "/etc/apt/keyrings/kubernetes-apt-keyring.gpg"
+	kubernetesAptList     = "/etc/apt/sources.list.d/kubernetes.list"
+	dockerRPMRepo         = "https://download.docker.com/linux/centos"
+
+	kubernetesModules = "overlay\nbr_netfilter\n"
+	kubernetesSysctl  = "net.bridge.bridge-nf-call-iptables = 1\n" +
+		"net.bridge.bridge-nf-call-ip6tables = 1\n" +
+		"net.ipv4.ip_forward = 1\n"
+
+	// containerdConfig enables the systemd cgroup driver the kubelet uses by default.
+	containerdConfig = "version = 2\n\n" +
+		"[plugins.\"io.containerd.grpc.v1.cri\".containerd.runtimes.runc.options]\n" +
+		"  SystemdCgroup = true\n"
+)
+
+//nolint:gochecknoglobals // Read-only patterns.
+var (
+	kubeadmTokenRe      = regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`)
+	kubeadmCACertHashRe = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	kubeadmCertKeyRe    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	kubeadmVersionRe    = regexp.MustCompile(`^v[0-9]+\.[0-9]+$`)
+	rancherVersionRe    = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+\+(k3s|rke2r)[0-9]+$`)
+	kubernetesLabelRe   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	kubernetesValueRe   = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// KubernetesDistribution is the installer of a Kubernetes node.
+type KubernetesDistribution string
+
+const (
+	// KubernetesKubeadm joins the node with kubeadm, using containerd and the pkgs.k8s.io packages.
+	KubernetesKubeadm KubernetesDistribution = "kubeadm"
+	// KubernetesK3s joins the node with the k3s install script.
+	KubernetesK3s KubernetesDistribution = "k3s"
+	// KubernetesRKE2 joins the node with the RKE2 install script.
+	KubernetesRKE2 KubernetesDistribution = "rke2"
+)
+
+// KubernetesRole is the role of a joining node.
+type KubernetesRole string
+
+const (
+	// KubernetesWorker joins the node as a worker.
+	KubernetesWorker KubernetesRole = "worker"
+	// KubernetesControlPlane joins the node as an additional control plane node.
+	KubernetesControlPlane KubernetesRole = "control-plane"
+)
+
+// KubernetesTaint is a taint of the node.
+type KubernetesTaint struct {
+	// Key is the taint key.
+	Key string `yaml:"key"`
+	// Value is the taint value, optional.
+	Value string `yaml:"value,omitempty"`
+	// Effect is NoSchedule, PreferNoSchedule or NoExecute.
+	Effect string `yaml:"effect"`
+}
+
+// String returns the taint in key=value:Effect form.
+func (t KubernetesTaint) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + t.Effect
+	}
+
+	return t.Key + "=" + t.Value + ":" + t.Effect
+}
+
+// KubernetesNode is the profile of a node joining an existing cluster.
+type KubernetesNode struct {
+	// Distribution is the installer of the node.
+	Distribution KubernetesDistribution
+	// Role is the role of the node, a worker by default.
+	Role KubernetesRole
+	// Version is the Kubernetes minor version of kubeadm nodes, e.g. "v1.30",
+	// or the k3s or RKE2 release, e.g. "v1.30.2+k3s1", the latest stable when empty.
+	Version string
+
+	// ControlPlaneEndpoint is the host:port of the API server kubeadm joins.
+	ControlPlaneEndpoint string
+	// CACertHashes are the "sha256:..." hashes of the cluster CA kubeadm pins.
+	CACertHashes []string
+	// CertificateKey decrypts the control plane certificates kubeadm downloads,
+	// required for kubeadm control plane nodes.
+	CertificateKey string
+	// ContainerRuntimePackage is the package of containerd for kubeadm. By
+	// default it is "containerd" on apt based distros, and "containerd.io"
+	// from the Docker CentOS repository on dnf based ones, as the RHEL family
+	// does not ship containerd. Set it to "containerd" for Fedora.
+	ContainerRuntimePackage string
+
+	// ServerURL is the URL of the k3s or RKE2 server, e.g. https://10.0.0.1:6443 or https://10.0.0.1:9345.
+	ServerURL string
+
+	// Token is the kubeadm bootstrap token, or the k3s or RKE2 join token.
+	Token string
+	// NodeLabels are the labels of the node.
+	NodeLabels map[string]string
+	// NodeTaints are the taints of the node.
+	NodeTaints []KubernetesTaint
+	// KubeletArgs are extra kubelet flags without the leading dashes, e.g. "max-pods": "110".
+	KubeletArgs map[string]string
+}
+
+// Validate checks that the settings of the distribution are present and well-formed.
+func (n *KubernetesNode) Validate() error {
+	switch n.Role {
+	case "", KubernetesWorker, KubernetesControlPlane:
+	default:
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidKubernetesNode, n.Role)
+	}
+
+	switch n.Distribution {
+	case KubernetesKubeadm:
+		if err := n.validateKubeadm(); err != nil {
+			return err
+		}
+	case KubernetesK3s, KubernetesRKE2:
+		if err := n.validateRancher(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown distribution %q", ErrInvalidKubernetesNode, n.Distribution)
+	}
+
+	for k, v := range n.NodeLabels {
+		if !kubernetesLabelRe.MatchString(k) || !kubernetesValueRe.MatchString(v) {
+			return fmt.Errorf("%w: invalid label %s=%s", ErrInvalidKubernetesNode, k, v)
+		}
+	}
+
+	for _, taint := range n.NodeTaints {
+		if !kubernetesLabelRe.MatchString(taint.Key) || !kubernetesValueRe.MatchString(taint.Value) {
+			return fmt.Errorf("%w: invalid taint %s", ErrInvalidKubernetesNode, taint)
+		}
+
+		switch taint.Effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return fmt.Errorf("%w: invalid taint effect %q", ErrInvalidKubernetesNode, taint.Effect)
+		}
+	}
+
+	for k, v := range n.KubeletArgs {
+		if k == "" || strings.HasPrefix(k, "-") || strings.ContainsAny(k+v, " \t\r\n=") {
+			return fmt.Errorf("%w: invalid kubelet argument %s=%s", ErrInvalidKubernetesNode, k, v)
+		}
+	}
+
+	return nil
+}
+
+func (n *KubernetesNode) validateKubeadm() error {
+	if _, port, err := net.SplitHostPort(n.ControlPlaneEndpoint); err != nil || port == "" {
+		return fmt.Errorf("%w: invalid control plane endpoint %q", ErrInvalidKubernetesNode, n.ControlPlaneEndpoint)
+	}
+
+	if !kubeadmTokenRe.MatchString(n.Token) {
+		return fmt.Errorf("%w: invalid bootstrap token", ErrInvalidKubernetesNode)
+	}
+
+	if len(n.CACertHashes) == 0 {
+		return fmt.Errorf("%w: kubeadm requires a CA certificate hash", ErrInvalidKubernetesNode)
+	}
+
+	for _, hash := range n.CACertHashes {
+		if !kubeadmCACertHashRe.MatchString(hash) {
+			return fmt.Errorf("%w: invalid CA certificate hash %q", ErrInvalidKubernetesNode, hash)
+		}
+	}
+
+	if n.Role == KubernetesControlPlane && !kubeadmCertKeyRe.MatchString(n.CertificateKey) {
+		return fmt.Errorf("%w: kubeadm control plane requires a certificate key", ErrInvalidKubernetesNode)
+	}
+
+	if !kubeadmVersionRe.MatchString(n.Version) {
+		return fmt.Errorf("%w: kubeadm requires a minor version like v1.30, got %q", ErrInvalidKubernetesNode, n.Version)
+	}
+
+	if strings.ContainsAny(n.ContainerRuntimePackage, " \t\r\n") {
+		return fmt.Errorf("%w: invalid container runtime package %q", ErrInvalidKubernetesNode, n.ContainerRuntimePackage)
+	}
+
+	return nil
+}
+
+func (n *KubernetesNode) validateRancher() error {
+	u, err := url.Parse(n.ServerURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: invalid server URL %q", ErrInvalidKubernetesNode, n.ServerURL)
+	}
+
+	if n.Token == "" || strings.ContainsAny(n.Token, " \t\r\n") {
+		return fmt.Errorf("%w: invalid join token", ErrInvalidKubernetesNode)
+	}
+
+	if n.Version != "" && !rancherVersionRe.MatchString(n.Version) {
+		return fmt.Errorf("%w: invalid %s version %q", ErrInvalidKubernetesNode, n.Distribution, n.Version)
+	}
+
+	return nil
+}
+
+// CloudConfig renders the profile for a guest using the package manager,
+// which selects the Kubernetes repository of kubeadm nodes.
+func (n *KubernetesNode) CloudConfig(pm PackageManager) (*CloudConfig, error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	cc := &CloudConfig{
+		WriteFiles: []WriteFile{
+			{Path: kubernetesModulesPath, Content: kubernetesModules, Permissions: "0644"},
+			{Path: kubernetesSysctlPath, Content: kubernetesSysctl, Permissions: "0644"},
+		},
+		RunCommands: []Command{
+			ExecCommand("modprobe", "overlay"),
+			ExecCommand("modprobe", "br_netfilter"),
+			ExecCommand("sysctl", "--system"),
+		},
+	}
+
+	var err error
+	if n.Distribution == KubernetesKubeadm {
+		err = n.kubeadm(cc, pm)
+	} else {
+		err = n.rancher(cc)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cc, nil
+}
+
+// kubeadmJoinConfiguration is the JoinConfiguration of kubeadm.
+// For more information see: https://kubernetes.io/docs/reference/config-api/kubeadm-config.v1beta3/
+type kubeadmJoinConfiguration struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Discovery  struct {
+		BootstrapToken struct {
+			APIServerEndpoint string   `yaml:"apiServerEndpoint"`
+			Token             string   `yaml:"token"`
+			CACertHashes      []string `yaml:"caCertHashes"`
+		} `yaml:"bootstrapToken"`
+	} `yaml:"discovery"`
+	NodeRegistration struct {
+		CRISocket        string            `yaml:"criSocket"`
+		KubeletExtraArgs map[string]string `yaml:"kubeletExtraArgs,omitempty"`
+		Taints           []KubernetesTaint `yaml:"taints,omitempty"`
+	} `yaml:"nodeRegistration"`
+	ControlPlane *struct {
+		CertificateKey string `yaml:"certificateKey"`
+	} `yaml:"controlPlane,omitempty"`
+}
+
+func (n *KubernetesNode) kubeadm(cc *CloudConfig, pm PackageManager) error {
+	var join kubeadmJoinConfiguration
+	join.APIVersion, join.Kind = "kubeadm.k8s.io/v1beta3", "JoinConfiguration"
+	join.Discovery.BootstrapToken.APIServerEndpoint = n.ControlPlaneEndpoint
+	join.Discovery.BootstrapToken.Token = n.Token
+	join.Discovery.BootstrapToken.CACertHashes = n.CACertHashes
+	join.NodeRegistration.CRISocket = "unix:///run/containerd/containerd.sock"
+	join.NodeRegistration.KubeletExtraArgs = maps.Clone(n.KubeletArgs)
+	join.NodeRegistration.Taints = n.NodeTaints
+
+	if labels := n.labels(); len(labels) > 0 {
+		if join.NodeRegistration.KubeletExtraArgs == nil {
+			join.NodeRegistration.KubeletExtraArgs = make(map[string]string)
+		}
+
+		join.NodeRegistration.KubeletExtraArgs["node-labels"] = strings.Join(labels, ",")
+	}
+
+	if n.Role == KubernetesControlPlane {
+		join.ControlPlane = &struct {
+			CertificateKey string `yaml:"certificateKey"`
+		}{n.CertificateKey}
+	}
+
+	data, err := yaml.Marshal(join)
+	if err != nil {
+		return fmt.Errorf("failed to marshal kubeadm join configuration: %w", err)
+	}
+
+	kubePackages := []string{"kubelet", "kubeadm", "kubectl"}
+	repo := "https://pkgs.k8s.io/core:/stable:/" + n.Version
+
+	cc.WriteFiles = append(cc.WriteFiles,
+		WriteFile{Path: containerdConfigPath, Content: containerdConfig, Permissions: "0644"},
+		WriteFile{Path: kubeadmJoinPath, Content: string(data), Permissions: "0600"},
+	)
+	runtime := n.ContainerRuntimePackage
+
+	switch pm.Name {
+	case "apt", "":
+		if runtime == "" {
+			runtime = "containerd"
+		}
+
+		// Unknown distros are assumed to be Debian based. The repository
+		// key is downloaded by runcmd, so the repository is written after
+		// the packages module, and the packages are installed by runcmd.
+		cc.Packages = append(cc.Packages, Package{Name: "ca-certificates"}, Package{Name: "curl"}, Package{Name: "gpg"})
+		cc.WriteFiles = append(cc.WriteFiles, WriteFile{
+			Path:        kubernetesAptList,
+			Content:     "deb [signed-by=" + kubernetesAptKeyring + "] " + repo + "/deb/ /\n",
+			Permissions: "0644",
+			Defer:       true,
+		})
+		cc.RunCommands = append(cc.RunCommands,
+			ExecCommand("mkdir", "-p", "-m", "0755", "/etc/apt/keyrings"),
+			ShellCommand("curl -fsSL "+repo+"/deb/Release.key | gpg --dearmor -o "+kubernetesAptKeyring),
+			ExecCommand("apt-get", "update"),
+			ExecCommand(append([]string{"apt-get", "install", "-y"}, kubePackages...)...),
+			ExecCommand(append([]string{"apt-mark", "hold"}, kubePackages...)...),
+		)
+	case "dnf":
+		enabled := true
+		cc.YumRepos = map[string]YumRepo{"kubernetes": {
+			Name:     "Kubernetes",
+			BaseURL:  repo + "/rpm/",
+			Enabled:  &enabled,
+			GPGCheck: &enabled,
+			GPGKey:   repo + "/rpm/repodata/repomd.xml.key",
+		}}
+
+		if runtime == "" {
+			runtime = "containerd.io"
+			cc.YumRepos["docker-ce-stable"] = YumRepo{
+				Name:     "Docker CE Stable",
+				BaseURL:  dockerRPMRepo + "/$releasever/$basearch/stable",
+				Enabled:  &enabled,
+				GPGCheck: &enabled,
+				GPGKey:   dockerRPMRepo + "/gpg",
+			}
+		}
+
+		for _, name := range kubePackages {
+			cc.Packages = append(cc.Packages, Package{Name: name})
+		}
+	default:
+		return fmt.Errorf("%w: kubeadm requires an apt or dnf based distro", ErrInvalidKubernetesNode)
+	}
+
+	cc.Packages = slices.Insert(cc.Packages, 0, Package{Name: runtime})
+
+	// The kubelet does not start with swap enabled, so the swap entries of
+	// fstab are commented out too, to keep swap off after a reboot.
+	cc.RunCommands = append(cc.RunCommands,
+		ExecCommand("sed", "-ri", `/^[^#].*[[:space:]]swap[[:space:]]/s/^/#/`, "/etc/fstab"),
+		ExecCommand("swapoff", "-a"),
+		ExecCommand("systemctl", "restart", "containerd"),
+		ExecCommand("systemctl", "enable", "--now", "kubelet"),
+		ExecCommand("kubeadm", "join", "--config", kubeadmJoinPath),
+	)
+
+	return nil
+}
+
+// rancherConfig is the config.yaml of k3s and RKE2.
+type rancherConfig struct {
+	Server     string   `yaml:"server"`
+	Token      string   `yaml:"token"`
+	NodeLabel  []string `yaml:"node-label,omitempty"`
+	NodeTaint  []string `yaml:"node-taint,omitempty"`
+	KubeletArg []string `yaml:"kubelet-arg,omitempty"`
+}
+
+func (n *KubernetesNode) rancher(cc *CloudConfig) error {
+	conf := rancherConfig{Server: n.ServerURL, Token: n.Token, NodeLabel: n.labels()}
+
+	for _, taint := range n.NodeTaints {
+		conf.NodeTaint = append(conf.NodeTaint, taint.String())
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(n.KubeletArgs)) {
+		conf.KubeletArg = append(conf.KubeletArg, k+"="+n.KubeletArgs[k])
+	}
+
+	data, err := yaml.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s configuration: %w", n.Distribution, err)
+	}
+
+	nodeType := "agent"
+	if n.Role == KubernetesControlPlane {
+		nodeType = "server"
+	}
+
+	cc.Packages = append(cc.Packages, Package{Name: "curl"})
+	cc.WriteFiles = append(cc.WriteFiles, WriteFile{
+		Path:        "/etc/rancher/" + string(n.Distribution) + "/config.yaml",
+		Content:     string(data),
+		Permissions: "0600",
+	})
+
+	// The version is validated, so it is safe in the shell command.
+	switch n.Distribution {
+	case KubernetesK3s:
+		env := "INSTALL_K3S_EXEC=" + nodeType
+		if n.Version != "" {
+			env += " INSTALL_K3S_VERSION=" + n.Version
+		}
+
+		cc.RunCommands = append(cc.RunCommands, ShellCommand("curl -sfL https://get.k3s.io | "+env+" sh -"))
+	case KubernetesRKE2:
+		env := "INSTALL_RKE2_TYPE=" + nodeType
+		if n.Version != "" {
+			env += " INSTALL_RKE2_VERSION=" + n.Version
+		}
+
+		cc.RunCommands = append(cc.RunCommands,
+			ShellCommand("curl -sfL https://get.rke2.io | "+env+" sh -"),
+			ExecCommand("systemctl", "enable", "--now", "rke2-"+nodeType+".service"),
+		)
+	}
+
+	return nil
+}
+
+// labels returns the node labels in key=value form, sorted by key.
+func (n *KubernetesNode) labels() []string {
+	labels := make([]string, 0, len(n.NodeLabels))
+	for _, k := range slices.Sorted(maps.Keys(n.NodeLabels)) {
+		labels = append(labels, k+"="+n.NodeLabels[k])
+	}
+
+	return labels
+}
+
+// ApplyKubernetesNode sets the node whose profile is merged into the
+// cloud-config of SetCloudConfig when the user-data is generated, replacing
+// the node of a previous call. The profile is merged with
+// "list(append)+dict(no_replace,recurse_list,recurse_dict)", so its lists are
+// appended and the existing settings are kept. The distro set with SetDistro
+// selects the package repository of kubeadm nodes.
+func (c *Config) ApplyKubernetesNode(n KubernetesNode) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	c.kubernetesNode = &n
+
+	return nil
+}
+
+// applyKubernetesNode returns cc with the profile of the node of ApplyKubernetesNode merged in.
+func (c *Config) applyKubernetesNode(cc *CloudConfig) (*CloudConfig, error) {
+	if c.kubernetesNode == nil {
+		return cc, nil
+	}
+
+	profile, err := c.kubernetesNode.CloudConfig(c.PackageManager())
+	if err != nil {
+		return nil, err
+	}
+
+	return Merge(cc, profile, MergeStrategy{
+		Dict: DictMerge{Mode: DictNoReplace, RecurseDict: true, RecurseList: true},
+		List: ListMerge{Mode: ListAppend},
+	})
+}
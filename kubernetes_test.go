@@ -0,0 +1,216 @@
+package cloudinit_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func kubeadmNode() cloudinit.KubernetesNode {
+	return cloudinit.KubernetesNode{
+		Distribution:         cloudinit.KubernetesKubeadm,
+		Version:              "v1.30",
+		ControlPlaneEndpoint: "10.0.0.10:6443",
+		Token:                "abcdef.0123456789abcdef",
+		CACertHashes:         []string{"sha256:" + strings.Repeat("ab", 32)},
+		NodeLabels:           map[string]string{"node.example.com/pool": "general"},
+		NodeTaints:           []cloudinit.KubernetesTaint{{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}},
+		KubeletArgs:          map[string]string{"max-pods": "110"},
+	}
+}
+
+func TestKubernetesKubeadmWorker(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetDistro(cloudinit.DistroUbuntu)
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		Packages:    []cloudinit.Package{{Name: "htop"}},
+		RunCommands: []cloudinit.Command{cloudinit.ShellCommand("echo first")},
+	})
+	require.NoError(t, c.ApplyKubernetesNode(kubeadmNode()))
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+
+	assert.Equal(t, []cloudinit.Package{
+		{Name: "htop"}, {Name: "containerd"}, {Name: "ca-certificates"}, {Name: "curl"}, {Name: "gpg"},
+	}, cc.Packages)
+	assert.Equal(t, cloudinit.ShellCommand("echo first"), cc.RunCommands[0])
+	assert.Equal(t, cloudinit.ExecCommand("kubeadm", "join", "--config", "/etc/kubernetes/kubeadm-join.yaml"),
+		cc.RunCommands[len(cc.RunCommands)-1])
+	assert.Contains(t, cc.RunCommands, cloudinit.ExecCommand("apt-mark", "hold", "kubelet", "kubeadm", "kubectl"))
+	assert.Contains(t, cc.RunCommands,
+		cloudinit.ExecCommand("sed", "-ri", `/^[^#].*[[:space:]]swap[[:space:]]/s/^/#/`, "/etc/fstab"))
+
+	files := make(map[string]cloudinit.WriteFile)
+	for _, f := range cc.WriteFiles {
+		files[f.Path] = f
+	}
+
+	assert.Equal(t, "overlay\nbr_netfilter\n", files["/etc/modules-load.d/kubernetes.conf"].Content)
+	assert.Contains(t, files["/etc/sysctl.d/99-kubernetes.conf"].Content, "net.ipv4.ip_forward = 1\n")
+	assert.Contains(t, files["/etc/containerd/config.toml"].Content, "SystemdCgroup = true")
+	assert.True(t, files["/etc/apt/sources.list.d/kubernetes.list"].Defer)
+	assert.Contains(t, files["/etc/apt/sources.list.d/kubernetes.list"].Content, "https://pkgs.k8s.io/core:/stable:/v1.30/deb/ /")
+
+	join := files["/etc/kubernetes/kubeadm-join.yaml"]
+	assert.Equal(t, "0600", join.Permissions)
+	assert.Equal(t, `apiVersion: kubeadm.k8s.io/v1beta3
+kind: JoinConfiguration
+discovery:
+    bootstrapToken:
+        apiServerEndpoint: 10.0.0.10:6443
+        token: abcdef.0123456789abcdef
+        caCertHashes:
+            - sha256:`+strings.Repeat("ab", 32)+`
+nodeRegistration:
+    criSocket: unix:///run/containerd/containerd.sock
+    kubeletExtraArgs:
+        max-pods: "110"
+        node-labels: node.example.com/pool=general
+    taints:
+        - key: dedicated
+          value: gpu
+          effect: NoSchedule
+`, join.Content)
+}
+
+func TestApplyKubernetesNodeOnGeneration(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetDistro(cloudinit.DistroUbuntu)
+	require.NoError(t, c.ApplyKubernetesNode(kubeadmNode()))
+
+	// The profile is merged when the user-data is generated, so the
+	// cloud-config and the distro may be set afterwards.
+	c.SetCloudConfig(&cloudinit.CloudConfig{Packages: []cloudinit.Package{{Name: "htop"}}})
+	c.SetDistro(cloudinit.DistroRocky)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	assert.Contains(t, cc.Packages, cloudinit.Package{Name: "htop"})
+	assert.Contains(t, cc.Packages, cloudinit.Package{Name: "kubeadm"})
+	assert.Contains(t, cc.YumRepos, "kubernetes")
+	assert.NotContains(t, cc.RunCommands, cloudinit.ExecCommand("apt-mark", "hold", "kubelet", "kubeadm", "kubectl"))
+
+	c.SetDistro(cloudinit.DistroAlpine)
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidKubernetesNode)
+}
+
+func TestKubernetesKubeadmControlPlane(t *testing.T) {
+	n := kubeadmNode()
+	n.Role = cloudinit.KubernetesControlPlane
+	require.ErrorIs(t, n.Validate(), cloudinit.ErrInvalidKubernetesNode)
+
+	n.CertificateKey = strings.Repeat("0f", 32)
+	cc, err := n.CloudConfig(cloudinit.DistroRocky.PackageManager())
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://pkgs.k8s.io/core:/stable:/v1.30/rpm/", cc.YumRepos["kubernetes"].BaseURL)
+	assert.Contains(t, cc.Packages, cloudinit.Package{Name: "kubeadm"})
+	assert.Contains(t, cc.Packages, cloudinit.Package{Name: "containerd.io"})
+	assert.Equal(t, "https://download.docker.com/linux/centos/gpg", cc.YumRepos["docker-ce-stable"].GPGKey)
+	assert.Contains(t, cc.WriteFiles[len(cc.WriteFiles)-1].Content, "controlPlane:\n    certificateKey: "+n.CertificateKey+"\n")
+
+	n.ContainerRuntimePackage = "containerd"
+	cc, err = n.CloudConfig(cloudinit.DistroRocky.PackageManager())
+	require.NoError(t, err)
+	assert.Contains(t, cc.Packages, cloudinit.Package{Name: "containerd"})
+	assert.NotContains(t, cc.YumRepos, "docker-ce-stable")
+
+	_, err = n.CloudConfig(cloudinit.DistroAlpine.PackageManager())
+	require.ErrorIs(t, err, cloudinit.ErrInvalidKubernetesNode)
+}
+
+func TestKubernetesRancher(t *testing.T) {
+	testCases := []struct {
+		name     string
+		node     cloudinit.KubernetesNode
+		path     string
+		commands []cloudinit.Command
+	}{
+		{
+			name: "k3s agent",
+			node: cloudinit.KubernetesNode{
+				Distribution: cloudinit.KubernetesK3s,
+				Version:      "v1.30.2+k3s1",
+				ServerURL:    "https://10.0.0.10:6443",
+				Token:        "K10secret::server:secret",
+				NodeLabels:   map[string]string{"pool": "edge"},
+			},
+			path: "/etc/rancher/k3s/config.yaml",
+			commands: []cloudinit.Command{
+				cloudinit.ShellCommand("curl -sfL https://get.k3s.io | INSTALL_K3S_EXEC=agent INSTALL_K3S_VERSION=v1.30.2+k3s1 sh -"),
+			},
+		},
+		{
+			name: "rke2 server",
+			node: cloudinit.KubernetesNode{
+				Distribution: cloudinit.KubernetesRKE2,
+				Role:         cloudinit.KubernetesControlPlane,
+				ServerURL:    "https://10.0.0.10:9345",
+				Token:        "secret",
+				NodeLabels:   map[string]string{"pool": "edge"},
+			},
+			path: "/etc/rancher/rke2/config.yaml",
+			commands: []cloudinit.Command{
+				cloudinit.ShellCommand("curl -sfL https://get.rke2.io | INSTALL_RKE2_TYPE=server sh -"),
+				cloudinit.ExecCommand("systemctl", "enable", "--now", "rke2-server.service"),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cc, err := tc.node.CloudConfig(cloudinit.DistroDebian.PackageManager())
+			require.NoError(t, err)
+
+			conf := cc.WriteFiles[len(cc.WriteFiles)-1]
+			assert.Equal(t, tc.path, conf.Path)
+			assert.Equal(t, "0600", conf.Permissions)
+			assert.Equal(t, "server: "+tc.node.ServerURL+"\ntoken: "+tc.node.Token+"\nnode-label:\n    - pool=edge\n", conf.Content)
+			assert.Equal(t, tc.commands, cc.RunCommands[len(cc.RunCommands)-len(tc.commands):])
+		})
+	}
+}
+
+func TestKubernetesNodeValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(n *cloudinit.KubernetesNode)
+	}{
+		{name: "unknown distribution", modify: func(n *cloudinit.KubernetesNode) { n.Distribution = "microk8s" }},
+		{name: "unknown role", modify: func(n *cloudinit.KubernetesNode) { n.Role = "etcd" }},
+		{name: "endpoint without port", modify: func(n *cloudinit.KubernetesNode) { n.ControlPlaneEndpoint = "10.0.0.10" }},
+		{name: "invalid token", modify: func(n *cloudinit.KubernetesNode) { n.Token = "secret" }},
+		{name: "missing hash", modify: func(n *cloudinit.KubernetesNode) { n.CACertHashes = nil }},
+		{name: "invalid hash", modify: func(n *cloudinit.KubernetesNode) { n.CACertHashes = []string{"sha256:abc"} }},
+		{name: "patch version", modify: func(n *cloudinit.KubernetesNode) { n.Version = "v1.30.2" }},
+		{name: "invalid label", modify: func(n *cloudinit.KubernetesNode) { n.NodeLabels = map[string]string{"a b": "c"} }},
+		{name: "invalid taint", modify: func(n *cloudinit.KubernetesNode) { n.NodeTaints[0].Effect = "Never" }},
+		{name: "invalid kubelet argument", modify: func(n *cloudinit.KubernetesNode) { n.KubeletArgs = map[string]string{"--v": "2"} }},
+		{name: "http server", modify: func(n *cloudinit.KubernetesNode) {
+			n.Distribution, n.ServerURL = cloudinit.KubernetesK3s, "http://10.0.0.10:6443"
+		}},
+		{name: "unsafe version", modify: func(n *cloudinit.KubernetesNode) {
+			n.Distribution, n.ServerURL, n.Version = cloudinit.KubernetesK3s, "https://10.0.0.10:6443", "v1; reboot"
+		}},
+	}
+
+	n := kubeadmNode()
+	require.NoError(t, n.Validate())
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n := kubeadmNode()
+			tc.modify(&n)
+			require.ErrorIs(t, n.Validate(), cloudinit.ErrInvalidKubernetesNode)
+
+			c := cloudinit.NewConfig()
+			require.ErrorIs(t, c.ApplyKubernetesNode(n), cloudinit.ErrInvalidKubernetesNode)
+		})
+	}
+}
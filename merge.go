@@ -0,0 +1,412 @@
+package cloudinit
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DictMergeMode selects how a key present in both dicts is resolved.
+type DictMergeMode string
+
+const (
+	// DictReplace makes the overlay value win for keys present in both dicts.
+	DictReplace DictMergeMode = "replace"
+	// DictNoReplace keeps the base value for keys present in both dicts,
+	// unless one of the recurse options applies to the value.
+	DictNoReplace DictMergeMode = "no_replace"
+)
+
+// ListMergeMode selects how two lists are combined.
+type ListMergeMode string
+
+const (
+	// ListAppend adds the overlay entries after the base entries.
+	ListAppend ListMergeMode = "append"
+	// ListPrepend adds the overlay entries before the base entries.
+	ListPrepend ListMergeMode = "prepend"
+	// ListReplace replaces the base entries with the overlay entries at the same index.
+	ListReplace ListMergeMode = "replace"
+	// ListNoReplace keeps the base entries at the same index.
+	ListNoReplace ListMergeMode = "no_replace"
+)
+
+// DictMerge configures the dict merger, the "dict(...)" part of merge_how.
+type DictMerge struct {
+	// Mode is the replace or no_replace behavior, no_replace when empty.
+	Mode DictMergeMode
+	// RecurseDict is accepted for compatibility, nested dicts are always
+	// merged with no_replace, like cloud-init does.
+	RecurseDict bool
+	// RecurseList merges nested lists with the list merger.
+	RecurseList bool
+	// RecurseStr merges nested strings with the string merger.
+	RecurseStr bool
+}
+
+// ListMerge configures the list merger, the "list(...)" part of merge_how.
+type ListMerge struct {
+	// Mode is the append, prepend, replace or no_replace behavior, replace when empty.
+	Mode ListMergeMode
+	// RecurseDict merges dicts found at the same index.
+	RecurseDict bool
+	// RecurseList merges lists found at the same index.
+	RecurseList bool
+	// RecurseStr merges strings found at the same index.
+	RecurseStr bool
+}
+
+// StrMerge configures the string merger, the "str(...)" part of merge_how.
+type StrMerge struct {
+	// Append concatenates the strings instead of replacing the base one.
+	Append bool
+}
+
+// MergeStrategy mirrors cloud-init's merge_how setting.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/merging.html
+type MergeStrategy struct {
+	Dict DictMerge
+	List ListMerge
+	Str  StrMerge
+}
+
+// DefaultMergeStrategy is the strategy cloud-init uses for cloud-config parts
+// that do not specify merge_how, "dict(replace)+list()+str()".
+//
+//nolint:gochecknoglobals // Read-only default, same as in cloud-init.
+var DefaultMergeStrategy = MergeStrategy{
+	Dict: DictMerge{Mode: DictReplace},
+	List: ListMerge{Mode: ListReplace},
+}
+
+// ParseMergeStrategy parses a merge_how string, e.g. "list(append)+dict(no_replace,recurse_list)+str()".
+func ParseMergeStrategy(s string) (MergeStrategy, error) {
+	var ms MergeStrategy
+
+	for _, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		open := strings.Index(part, "(")
+		if open < 0 || !strings.HasSuffix(part, ")") {
+			return ms, fmt.Errorf("invalid merger %q", part)
+		}
+
+		name := part[:open]
+		opts := make([]string, 0)
+
+		for _, opt := range strings.Split(part[open+1:len(part)-1], ",") {
+			if opt = strings.TrimSpace(opt); opt != "" {
+				opts = append(opts, opt)
+			}
+		}
+
+		var err error
+
+		switch name {
+		case "dict":
+			err = ms.Dict.parse(opts)
+		case "list":
+			err = ms.List.parse(opts)
+		case "str":
+			err = ms.Str.parse(opts)
+		default:
+			err = fmt.Errorf("unknown merger %q", name)
+		}
+
+		if err != nil {
+			return ms, err
+		}
+	}
+
+	return ms, nil
+}
+
+func (d *DictMerge) parse(opts []string) error {
+	for _, opt := range opts {
+		switch opt {
+		case string(DictReplace), string(DictNoReplace):
+			d.Mode = DictMergeMode(opt)
+		case "recurse_dict":
+			d.RecurseDict = true
+		case "recurse_list", "recurse_array":
+			d.RecurseList = true
+		case "recurse_str":
+			d.RecurseStr = true
+		default:
+			return fmt.Errorf("unknown dict merge option %q", opt)
+		}
+	}
+
+	return nil
+}
+
+func (l *ListMerge) parse(opts []string) error {
+	for _, opt := range opts {
+		switch opt {
+		case string(ListAppend), string(ListPrepend), string(ListReplace), string(ListNoReplace):
+			l.Mode = ListMergeMode(opt)
+		case "recurse_dict":
+			l.RecurseDict = true
+		case "recurse_list", "recurse_array":
+			l.RecurseList = true
+		case "recurse_str":
+			l.RecurseStr = true
+		default:
+			return fmt.Errorf("unknown list merge option %q", opt)
+		}
+	}
+
+	return nil
+}
+
+func (s *StrMerge) parse(opts []string) error {
+	for _, opt := range opts {
+		if opt != "append" {
+			return fmt.Errorf("unknown str merge option %q", opt)
+		}
+
+		s.Append = true
+	}
+
+	return nil
+}
+
+// String returns the strategy in merge_how format.
+func (ms MergeStrategy) String() string {
+	dictMode := ms.Dict.Mode
+	if dictMode == "" {
+		dictMode = DictNoReplace
+	}
+
+	dict := []string{string(dictMode)}
+	dict = append(dict, recurseOptions(ms.Dict.RecurseDict, ms.Dict.RecurseList, ms.Dict.RecurseStr)...)
+
+	list := make([]string, 0)
+	if ms.List.Mode != "" {
+		list = append(list, string(ms.List.Mode))
+	}
+
+	list = append(list, recurseOptions(ms.List.RecurseDict, ms.List.RecurseList, ms.List.RecurseStr)...)
+
+	str := ""
+	if ms.Str.Append {
+		str = "append"
+	}
+
+	return fmt.Sprintf("dict(%s)+list(%s)+str(%s)", strings.Join(dict, ","), strings.Join(list, ","), str)
+}
+
+func recurseOptions(dict, list, str bool) []string {
+	opts := make([]string, 0)
+
+	if dict {
+		opts = append(opts, "recurse_dict")
+	}
+
+	if list {
+		opts = append(opts, "recurse_list")
+	}
+
+	if str {
+		opts = append(opts, "recurse_str")
+	}
+
+	return opts
+}
+
+// Merge merges overlay into base like cloud-init merges two cloud-config parts,
+// and returns the result as a new CloudConfig. Neither argument is modified.
+//
+// The merge works on the rendered keys, so every CloudConfig field is covered
+// and unset (omitted) fields never override the other side.
+func Merge(base, overlay *CloudConfig, strategy MergeStrategy) (*CloudConfig, error) {
+	baseMap, err := cloudConfigToMap(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert base config: %w", err)
+	}
+
+	overlayMap, err := cloudConfigToMap(overlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert overlay config: %w", err)
+	}
+
+	merged := strategy.merge(baseMap, overlayMap)
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+
+	cc := new(CloudConfig)
+	if err := yaml.Unmarshal(data, cc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal merged config: %w", err)
+	}
+
+	return cc, nil
+}
+
+func cloudConfigToMap(cc *CloudConfig) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if cc == nil {
+		return m, nil
+	}
+
+	data, err := yaml.Marshal(cc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// cloneCloudConfig returns a deep copy of cc, or an empty config if cc is nil.
+func cloneCloudConfig(cc *CloudConfig) (*CloudConfig, error) {
+	if cc == nil {
+		return new(CloudConfig), nil
+	}
+
+	clone, err := Merge(nil, cc, DefaultMergeStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone cloud-config: %w", err)
+	}
+
+	return clone, nil
+}
+
+// merge dispatches on the type of the base value, like cloud-init's LookupMerger.
+// Values without a merger (numbers, booleans) are replaced by the overlay value.
+func (ms MergeStrategy) merge(value, mergeWith interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return ms.mergeDict(v, mergeWith)
+	case []interface{}:
+		return ms.mergeList(v, mergeWith)
+	case string:
+		return ms.mergeStr(v, mergeWith)
+	default:
+		return mergeWith
+	}
+}
+
+func (ms MergeStrategy) mergeDict(value map[string]interface{}, mergeWith interface{}) interface{} {
+	with, ok := mergeWith.(map[string]interface{})
+	if !ok {
+		return value
+	}
+
+	merged := make(map[string]interface{}, len(value)+len(with))
+	for k, v := range value {
+		merged[k] = v
+	}
+
+	for k, newV := range with {
+		oldV, exists := merged[k]
+		if !exists {
+			merged[k] = newV
+
+			continue
+		}
+
+		merged[k] = ms.mergeSameKey(oldV, newV)
+	}
+
+	return merged
+}
+
+func (ms MergeStrategy) mergeSameKey(oldV, newV interface{}) interface{} {
+	if ms.Dict.Mode == DictReplace {
+		return newV
+	}
+
+	switch newV.(type) {
+	case []interface{}:
+		if ms.Dict.RecurseList {
+			return ms.merge(oldV, newV)
+		}
+	case string:
+		if ms.Dict.RecurseStr {
+			return ms.merge(oldV, newV)
+		}
+	case map[string]interface{}:
+		// cloud-init always recurses into dicts for backwards compatibility.
+		return ms.merge(oldV, newV)
+	}
+
+	return oldV
+}
+
+func (ms MergeStrategy) mergeList(value []interface{}, mergeWith interface{}) interface{} {
+	with, isList := mergeWith.([]interface{})
+
+	mode := ms.List.Mode
+	if mode == "" {
+		mode = ListReplace
+	}
+
+	if mode == ListReplace && !isList {
+		return mergeWith
+	}
+
+	if !isList {
+		with = []interface{}{mergeWith}
+	}
+
+	merged := make([]interface{}, 0, len(value)+len(with))
+
+	switch mode {
+	case ListPrepend:
+		merged = append(merged, with...)
+		return append(merged, value...)
+	case ListAppend:
+		merged = append(merged, value...)
+		return append(merged, with...)
+	case ListReplace, ListNoReplace:
+	}
+
+	merged = append(merged, value...)
+	for i := 0; i < len(merged) && i < len(with); i++ {
+		merged[i] = ms.mergeSameIndex(merged[i], with[i])
+	}
+
+	return merged
+}
+
+func (ms MergeStrategy) mergeSameIndex(oldV, newV interface{}) interface{} {
+	if ms.List.Mode == ListNoReplace {
+		return oldV
+	}
+
+	switch newV.(type) {
+	case []interface{}:
+		if ms.List.RecurseList {
+			return ms.merge(oldV, newV)
+		}
+	case string:
+		if ms.List.RecurseStr {
+			return ms.merge(oldV, newV)
+		}
+	case map[string]interface{}:
+		if ms.List.RecurseDict {
+			return ms.merge(oldV, newV)
+		}
+	}
+
+	return newV
+}
+
+func (ms MergeStrategy) mergeStr(value string, mergeWith interface{}) interface{} {
+	with, ok := mergeWith.(string)
+	if !ok || !ms.Str.Append {
+		return mergeWith
+	}
+
+	return value + with
+}
@@ -0,0 +1,113 @@
+package cloudinit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestParseMergeStrategy(t *testing.T) {
+	ms, err := cloudinit.ParseMergeStrategy("list(append)+dict(no_replace,recurse_list)+str()")
+	require.NoError(t, err)
+
+	assert.Equal(t, cloudinit.ListAppend, ms.List.Mode)
+	assert.Equal(t, cloudinit.DictNoReplace, ms.Dict.Mode)
+	assert.True(t, ms.Dict.RecurseList)
+	assert.False(t, ms.Str.Append)
+	assert.Equal(t, "dict(no_replace,recurse_list)+list(append)+str()", ms.String())
+
+	_, err = cloudinit.ParseMergeStrategy("dict(overwrite)")
+	require.Error(t, err)
+
+	_, err = cloudinit.ParseMergeStrategy("tuple()")
+	require.Error(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	base := &cloudinit.CloudConfig{
+		Packages:    []cloudinit.Package{{Name: "curl"}},
+		RunCommands: []cloudinit.Command{cloudinit.ShellCommand("echo base")},
+		Timezone:    "UTC",
+		Growpart:    &cloudinit.GrowpartConfig{Mode: "auto", Devices: []string{"/"}},
+	}
+	overlay := &cloudinit.CloudConfig{
+		Packages: []cloudinit.Package{{Name: "htop"}},
+		Timezone: "Europe/Budapest",
+		Locale:   "hu_HU.UTF-8",
+		Growpart: &cloudinit.GrowpartConfig{Devices: []string{"/dev/vda1"}},
+	}
+
+	t.Run("default replaces", func(t *testing.T) {
+		cc, err := cloudinit.Merge(base, overlay, cloudinit.DefaultMergeStrategy)
+		require.NoError(t, err)
+
+		assert.Equal(t, []cloudinit.Package{{Name: "htop"}}, cc.Packages)
+		assert.Equal(t, []cloudinit.Command{cloudinit.ShellCommand("echo base")}, cc.RunCommands)
+		assert.Equal(t, "Europe/Budapest", cc.Timezone)
+		assert.Equal(t, "hu_HU.UTF-8", cc.Locale)
+		assert.Equal(t, &cloudinit.GrowpartConfig{Devices: []string{"/dev/vda1"}}, cc.Growpart)
+	})
+
+	t.Run("append lists", func(t *testing.T) {
+		ms, err := cloudinit.ParseMergeStrategy("list(append)+dict(no_replace,recurse_list)+str()")
+		require.NoError(t, err)
+
+		cc, err := cloudinit.Merge(base, overlay, ms)
+		require.NoError(t, err)
+
+		assert.Equal(t, []cloudinit.Package{{Name: "curl"}, {Name: "htop"}}, cc.Packages)
+		assert.Equal(t, "UTC", cc.Timezone)
+		assert.Equal(t, "hu_HU.UTF-8", cc.Locale)
+		// Dicts are always recursed, keeping the base mode and appending the devices.
+		assert.Equal(t, &cloudinit.GrowpartConfig{Mode: "auto", Devices: []string{"/", "/dev/vda1"}}, cc.Growpart)
+	})
+
+	t.Run("prepend and recurse dicts", func(t *testing.T) {
+		ms, err := cloudinit.ParseMergeStrategy("list(prepend)+dict(no_replace,recurse_list,recurse_dict)")
+		require.NoError(t, err)
+
+		cc, err := cloudinit.Merge(base, overlay, ms)
+		require.NoError(t, err)
+
+		assert.Equal(t, []cloudinit.Package{{Name: "htop"}, {Name: "curl"}}, cc.Packages)
+		assert.Equal(t, &cloudinit.GrowpartConfig{Mode: "auto", Devices: []string{"/dev/vda1", "/"}}, cc.Growpart)
+	})
+
+	t.Run("replace by index", func(t *testing.T) {
+		ms, err := cloudinit.ParseMergeStrategy("list(replace)+dict(no_replace,recurse_list)")
+		require.NoError(t, err)
+
+		cc, err := cloudinit.Merge(
+			&cloudinit.CloudConfig{RunCommands: []cloudinit.Command{cloudinit.ShellCommand("a"), cloudinit.ShellCommand("b"), cloudinit.ShellCommand("c")}},
+			&cloudinit.CloudConfig{RunCommands: []cloudinit.Command{cloudinit.ShellCommand("x")}},
+			ms,
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, []cloudinit.Command{cloudinit.ShellCommand("x"), cloudinit.ShellCommand("b"), cloudinit.ShellCommand("c")}, cc.RunCommands)
+	})
+
+	t.Run("inputs untouched", func(t *testing.T) {
+		_, err := cloudinit.Merge(base, overlay, cloudinit.MergeStrategy{List: cloudinit.ListMerge{Mode: cloudinit.ListAppend}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []cloudinit.Package{{Name: "curl"}}, base.Packages)
+		assert.Equal(t, []cloudinit.Package{{Name: "htop"}}, overlay.Packages)
+	})
+}
+
+func TestConfigSetCloudConfig(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		Packages: []cloudinit.Package{{Name: "curl"}},
+		Timezone: "UTC",
+	})
+	c.EnableGuestAgent()
+
+	content := string(c.GenerateConfigContent())
+	assert.Contains(t, content, "timezone: UTC")
+	assert.Contains(t, content, "- curl\n")
+	assert.Contains(t, content, "- qemu-guest-agent\n")
+}
@@ -0,0 +1,42 @@
+package cloudinit
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+func (c *Config) generateNoCloudNetworkConfig() ([]byte, error) {
+	nc := NetworkConfigFile{
+		Network: Network{
+			Version: 1,
+			Config:  make([]NetworkConfig, 0, len(c.networkInterfaces)),
+		},
+	}
+
+	for _, mac := range c.interfaceMACs() {
+		iface := c.networkInterfaces[mac]
+
+		nc.Network.Config = append(nc.Network.Config, NetworkConfig{
+			Type:       NetworkConfigTypePhysical,
+			Name:       fmt.Sprintf("eth%d", len(nc.Network.Config)),
+			MACAddress: mac,
+			Subnets: []Subnet{
+				{
+					Type:        SubnetTypeStatic,
+					Address:     iface.Address,
+					Gateway:     iface.Gateway,
+					Nameservers: iface.Nameservers,
+				},
+			},
+		})
+	}
+
+	buf := new(bytes.Buffer)
+	if err := yaml.NewEncoder(buf).Encode(nc); err != nil {
+		return nil, fmt.Errorf("failed to marshal network-config: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // Validate time zones without the zoneinfo of the host.
+)
+
+// ErrInvalidTimeConfig is returned for invalid timezone, locale and ntp settings.
+var ErrInvalidTimeConfig = errors.New("invalid time configuration")
+
+// localeRe matches POSIX locale names, e.g. "en_US.UTF-8" or "sr_RS@latin".
+var localeRe = regexp.MustCompile(`^(C|POSIX|[a-z]{2,3}(_[A-Z]{2})?)(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+// NTP clients supported by the ntp module.
+const (
+	NTPClientAuto      = "auto"
+	NTPClientChrony    = "chrony"
+	NTPClientTimesyncd = "systemd-timesyncd"
+	NTPClientNTP       = "ntp"
+	NTPClientNTPDate   = "ntpdate"
+	NTPClientOpenNTPD  = "openntpd"
+)
+
+// NTPConfig holds the configuration of the ntp module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#ntp
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type NTPConfig struct {
+	// Enabled enables the module, true by default in cloud-init.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// NTPClient is the client to configure, the distro default when empty or "auto".
+	NTPClient string `yaml:"ntp_client,omitempty"`
+	// Servers are the NTP servers.
+	Servers []string `yaml:"servers,omitempty"`
+	// Pools are the NTP server pools, e.g. "0.pool.ntp.org".
+	Pools []string `yaml:"pools,omitempty"`
+	// Peers are the NTP peers.
+	Peers []string `yaml:"peers,omitempty"`
+	// Allow are the networks allowed to query the NTP server, e.g. "10.0.0.0/8".
+	Allow []string `yaml:"allow,omitempty"`
+	// Config overrides the settings of the NTP client.
+	Config *NTPClientConfig `yaml:"config,omitempty"`
+}
+
+// NTPClientConfig overrides the built-in settings of an NTP client.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type NTPClientConfig struct {
+	// ConfPath is the path of the client configuration file.
+	ConfPath string `yaml:"confpath,omitempty"`
+	// CheckExe is the executable whose presence selects the client.
+	CheckExe string `yaml:"check_exe,omitempty"`
+	// Packages are the packages installing the client.
+	Packages []string `yaml:"packages,omitempty"`
+	// ServiceName is the service of the client.
+	ServiceName string `yaml:"service_name,omitempty"`
+	// Template is a Jinja template of the configuration file, with the
+	// servers, pools, peers and allow variables.
+	Template string `yaml:"template,omitempty"`
+}
+
+// Validate checks the client name and that servers and pools are single hostnames or addresses.
+func (n *NTPConfig) Validate() error {
+	switch n.NTPClient {
+	case "", NTPClientAuto, NTPClientChrony, NTPClientTimesyncd, NTPClientNTP, NTPClientNTPDate, NTPClientOpenNTPD:
+	default:
+		if n.Config == nil {
+			return fmt.Errorf("%w: unknown NTP client %q without config", ErrInvalidTimeConfig, n.NTPClient)
+		}
+	}
+
+	for _, hosts := range [][]string{n.Servers, n.Pools, n.Peers, n.Allow} {
+		for _, host := range hosts {
+			if host == "" || strings.ContainsAny(host, " \t\r\n") {
+				return fmt.Errorf("%w: invalid NTP host %q", ErrInvalidTimeConfig, host)
+			}
+		}
+	}
+
+	if n.Config != nil && n.Config.Template != "" && !strings.HasPrefix(n.Config.Template, jinjaTemplateHeader) {
+		return fmt.Errorf("%w: NTP template must start with %q", ErrInvalidTimeConfig, strings.TrimSpace(jinjaTemplateHeader))
+	}
+
+	return nil
+}
+
+// SetTimezone sets the time zone of the guest, validated against the IANA
+// time zone database embedded in the binary.
+func (c *Config) SetTimezone(tz string) error {
+	if tz == "" || tz == "Local" {
+		return fmt.Errorf("%w: invalid time zone %q", ErrInvalidTimeConfig, tz)
+	}
+
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidTimeConfig, tz)
+	}
+
+	c.timezone = tz
+
+	return nil
+}
+
+// SetLocale sets the system locale of the guest, e.g. "en_US.UTF-8". The
+// locale is generated by cloud-init if the distro does not ship it.
+func (c *Config) SetLocale(locale string) error {
+	if !localeRe.MatchString(locale) {
+		return fmt.Errorf("%w: invalid locale %q", ErrInvalidTimeConfig, locale)
+	}
+
+	c.locale = locale
+
+	return nil
+}
+
+// SetNTP sets the configuration of the ntp module.
+func (c *Config) SetNTP(ntp NTPConfig) error {
+	if err := ntp.Validate(); err != nil {
+		return err
+	}
+
+	c.ntp = &ntp
+
+	return nil
+}
+
+// applyTime writes the timezone, locale and ntp settings.
+func (c *Config) applyTime(cc *CloudConfig) {
+	if c.timezone != "" {
+		cc.Timezone = c.timezone
+	}
+
+	if c.locale != "" {
+		cc.Locale = c.locale
+	}
+
+	if c.ntp != nil {
+		cc.NTP = c.ntp
+	}
+}
+
+// extractTime moves the settings written by applyTime back into Config.
+func (c *Config) extractTime(cc *CloudConfig) {
+	c.timezone, c.locale, c.ntp = cc.Timezone, cc.Locale, cc.NTP
+	cc.Timezone, cc.Locale, cc.NTP = "", "", nil
+}
@@ -0,0 +1,58 @@
+package cloudinit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTimezoneAndLocale(t *testing.T) {
+	c := cloudinit.NewConfig()
+	require.NoError(t, c.SetTimezone("Europe/Budapest"))
+	require.NoError(t, c.SetLocale("hu_HU.UTF-8"))
+
+	for _, tz := range []string{"", "Local", "Europe/Atlantis", "../etc/passwd"} {
+		require.ErrorIs(t, c.SetTimezone(tz), cloudinit.ErrInvalidTimeConfig, tz)
+	}
+
+	for _, locale := range []string{"C.UTF-8", "en_US", "sr_RS@latin"} {
+		require.NoError(t, c.SetLocale(locale), locale)
+	}
+
+	require.ErrorIs(t, c.SetLocale("en_US.UTF-8\nruncmd"), cloudinit.ErrInvalidTimeConfig)
+}
+
+func TestNTP(t *testing.T) {
+	enabled := true
+	ntp := cloudinit.NTPConfig{
+		Enabled:   &enabled,
+		NTPClient: cloudinit.NTPClientChrony,
+		Servers:   []string{"ntp1.example.com"},
+		Pools:     []string{"0.pool.ntp.org", "1.pool.ntp.org"},
+		Config: &cloudinit.NTPClientConfig{
+			ConfPath: "/etc/chrony/chrony.conf",
+			Template: "## template:jinja\n{% for pool in pools %}pool {{pool}} iburst\n{% endfor %}",
+		},
+	}
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{Timezone: "UTC"})
+	require.NoError(t, c.SetTimezone("Europe/Budapest"))
+	require.NoError(t, c.SetNTP(ntp))
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), "ntp_client: chrony\n")
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, "Europe/Budapest", cc.Timezone)
+	assert.Equal(t, &ntp, cc.NTP)
+
+	require.ErrorIs(t, c.SetNTP(cloudinit.NTPConfig{NTPClient: "ptpd"}), cloudinit.ErrInvalidTimeConfig)
+	require.ErrorIs(t, c.SetNTP(cloudinit.NTPConfig{Servers: []string{"a b"}}), cloudinit.ErrInvalidTimeConfig)
+	require.ErrorIs(t, c.SetNTP(cloudinit.NTPConfig{Config: &cloudinit.NTPClientConfig{Template: "server x"}}),
+		cloudinit.ErrInvalidTimeConfig)
+}
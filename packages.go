@@ -0,0 +1,87 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPackageConfig is returned for invalid packages and snap settings.
+var ErrInvalidPackageConfig = errors.New("invalid package configuration")
+
+// Package is an entry of the packages module, optionally pinned to a version.
+type Package struct {
+	// Name is the package name.
+	Name string
+	// Version is the exact version to install, the latest when empty.
+	Version string
+}
+
+// MarshalYAML writes the package name, or a [name, version] pair when pinned.
+func (p Package) MarshalYAML() (interface{}, error) {
+	if p.Version == "" {
+		return p.Name, nil
+	}
+
+	return []string{p.Name, p.Version}, nil
+}
+
+// UnmarshalYAML accepts a package name or a [name, version] pair.
+func (p *Package) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*p = Package{Name: node.Value}
+	case yaml.SequenceNode:
+		var pair []string
+		if err := node.Decode(&pair); err != nil {
+			return fmt.Errorf("line %d: invalid package: %w", node.Line, err)
+		}
+
+		if len(pair) != 2 {
+			return fmt.Errorf("line %d: package must be a [name, version] pair", node.Line)
+		}
+
+		*p = Package{Name: pair[0], Version: pair[1]}
+	default:
+		return fmt.Errorf("line %d: invalid package", node.Line)
+	}
+
+	return nil
+}
+
+// Validate checks that the name and the version are single words.
+func (p Package) Validate() error {
+	if p.Name == "" || strings.ContainsAny(p.Name, " \t\r\n") || strings.ContainsAny(p.Version, " \t\r\n") {
+		return fmt.Errorf("%w: invalid package %q %q", ErrInvalidPackageConfig, p.Name, p.Version)
+	}
+
+	return nil
+}
+
+// SnapConfig holds the configuration of the snap module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#snap
+type SnapConfig struct {
+	// Assertions are signed assertions added with snap ack before the commands run.
+	Assertions []string `yaml:"assertions,omitempty"`
+	// Commands are run in order after the assertions, e.g. "snap install amazon-ssm-agent --revision=9565".
+	Commands []Command `yaml:"commands,omitempty"`
+}
+
+// Validate checks that every assertion has a type header and no command is empty.
+func (s *SnapConfig) Validate() error {
+	for _, assertion := range s.Assertions {
+		if !strings.HasPrefix(assertion, "type: ") && !strings.Contains(assertion, "\ntype: ") {
+			return fmt.Errorf("%w: assertion without type header", ErrInvalidPackageConfig)
+		}
+	}
+
+	for _, cmd := range s.Commands {
+		if strings.TrimSpace(cmd.String()) == "" {
+			return fmt.Errorf("%w: empty snap command", ErrInvalidPackageConfig)
+		}
+	}
+
+	return nil
+}
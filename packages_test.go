@@ -0,0 +1,45 @@
+package cloudinit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPackagesAndSnap(t *testing.T) {
+	snap := &cloudinit.SnapConfig{
+		Assertions: []string{"type: account-key\nauthority-id: canonical\n"},
+		Commands:   []cloudinit.Command{cloudinit.ExecCommand("snap", "install", "amazon-ssm-agent", "--classic", "--revision=9565")},
+	}
+	require.NoError(t, snap.Validate())
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		PackageRebootIfRequired: true,
+		Packages:                []cloudinit.Package{{Name: "curl"}, {Name: "datadog-agent", Version: "1:7.52.0-1"}},
+		Snap:                    snap,
+	})
+	c.EnableGuestAgent()
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), `packages:
+    - curl
+    - - datadog-agent
+      - 1:7.52.0-1
+    - qemu-guest-agent
+`)
+	assert.Contains(t, string(content), "package_reboot_if_required: true\n")
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, cloudinit.Package{Name: "datadog-agent", Version: "1:7.52.0-1"}, cc.Packages[1])
+	assert.Equal(t, snap, cc.Snap)
+
+	require.Error(t, yaml.Unmarshal([]byte("packages: [[curl]]"), &cc))
+	require.ErrorIs(t, cloudinit.Package{Name: "two words"}.Validate(), cloudinit.ErrInvalidPackageConfig)
+	require.ErrorIs(t, (&cloudinit.SnapConfig{Assertions: []string{"no header"}}).Validate(),
+		cloudinit.ErrInvalidPackageConfig)
+}
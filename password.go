@@ -0,0 +1,173 @@
+package cloudinit
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/blowfish"
+)
+
+// PasswordHasher hashes passwords into the crypt(3) format of /etc/shadow.
+type PasswordHasher interface {
+	// Hash hashes the password, reading the salt from random.
+	Hash(password string, random io.Reader) (string, error)
+}
+
+// SHA512Crypt hashes passwords with SHA-512 crypt ($6$), which every glibc
+// and musl based distribution can verify.
+type SHA512Crypt struct {
+	// Rounds is the number of rounds, 5000 when zero.
+	Rounds int
+}
+
+// Hash implements PasswordHasher.
+func (h SHA512Crypt) Hash(password string, random io.Reader) (string, error) {
+	return sha512Crypt(password, h.Rounds, random)
+}
+
+// SHA256Crypt hashes passwords with SHA-256 crypt ($5$).
+type SHA256Crypt struct {
+	// Rounds is the number of rounds, 5000 when zero.
+	Rounds int
+}
+
+// Hash implements PasswordHasher.
+func (h SHA256Crypt) Hash(password string, random io.Reader) (string, error) {
+	return sha256Crypt(password, h.Rounds, random)
+}
+
+// Yescrypt hashes passwords with yescrypt ($y$), the default of recent
+// Debian, Ubuntu and Fedora releases. It needs libxcrypt on the guest.
+type Yescrypt struct {
+	// Cost is the base 2 logarithm of the memory cost N, 12 when zero like
+	// the "$y$j9T$" default of libxcrypt.
+	Cost int
+}
+
+// Hash implements PasswordHasher.
+func (h Yescrypt) Hash(password string, random io.Reader) (string, error) {
+	return yescryptHash(password, h.Cost, random)
+}
+
+// Bcrypt hashes passwords with bcrypt ($2a$). glibc without libxcrypt can
+// not verify it, e.g. on RHEL 8.
+type Bcrypt struct {
+	// Cost is the bcrypt cost, bcrypt.DefaultCost when zero.
+	Cost int
+}
+
+// Hash implements PasswordHasher.
+func (h Bcrypt) Hash(password string, random io.Reader) (string, error) {
+	cost := h.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	return bcryptHash([]byte(password), cost, random)
+}
+
+// DefaultPasswordHasher is used when neither a hasher nor a distro is set.
+//
+//nolint:gochecknoglobals // Read-only default.
+var DefaultPasswordHasher PasswordHasher = SHA512Crypt{}
+
+// HashPassword hashes the password for the /etc/shadow file with the given
+// hasher, or DefaultPasswordHasher when it is nil.
+func HashPassword(password string, hasher PasswordHasher) (string, error) {
+	if hasher == nil {
+		hasher = DefaultPasswordHasher
+	}
+
+	hash, err := hasher.Hash(password, rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return hash, nil
+}
+
+const (
+	randomPasswordLength   = 20
+	randomPasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+)
+
+// randomPassword generates a password of unambiguous letters and digits.
+func randomPassword(random io.Reader) (string, error) {
+	// Bytes above the largest multiple of the alphabet size are dropped to avoid bias.
+	limit := 256 - 256%len(randomPasswordAlphabet)
+
+	password := make([]byte, 0, randomPasswordLength)
+	buf := make([]byte, randomPasswordLength)
+
+	for len(password) < randomPasswordLength {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return "", fmt.Errorf("failed to read random bytes: %w", err)
+		}
+
+		for _, b := range buf {
+			if int(b) < limit && len(password) < randomPasswordLength {
+				password = append(password, randomPasswordAlphabet[int(b)%len(randomPasswordAlphabet)])
+			}
+		}
+	}
+
+	return string(password), nil
+}
+
+// bcryptEncoding is the base64 alphabet used by bcrypt.
+//
+//nolint:gochecknoglobals // Encoding table.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").
+	WithPadding(base64.NoPadding)
+
+// bcryptMagic is the "OrpheanBeholderScryDoubt" text bcrypt encrypts.
+//
+//nolint:gochecknoglobals // Constant cipher input.
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+// bcryptHash hashes the password with bcrypt, reading the salt from random.
+// It is equivalent to bcrypt.GenerateFromPassword, which always uses
+// crypto/rand and thus cannot produce reproducible hashes.
+func bcryptHash(password []byte, cost int, random io.Reader) (string, error) {
+	if len(password) > 72 {
+		return "", bcrypt.ErrPasswordTooLong
+	}
+
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", bcrypt.InvalidCostError(cost)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return "", fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	// C implementations use the trailing NUL of the key during expansion.
+	key := append(append(make([]byte, 0, len(password)+1), password...), 0)
+
+	c, err := blowfish.NewSaltedCipher(key, salt)
+	if err != nil {
+		return "", err
+	}
+
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		blowfish.ExpandKey(key, c)
+		blowfish.ExpandKey(salt, c)
+	}
+
+	data := make([]byte, len(bcryptMagic))
+	copy(data, bcryptMagic)
+
+	for i := 0; i < len(data); i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(data[i:i+8], data[i:i+8])
+		}
+	}
+
+	// Only 23 of the 24 encrypted bytes are encoded, for compatibility with C implementations.
+	return fmt.Sprintf("$2a$%02d$%s%s", cost,
+		bcryptEncoding.EncodeToString(salt), bcryptEncoding.EncodeToString(data[:23])), nil
+}
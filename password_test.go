@@ -0,0 +1,129 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	random := bytes.Repeat([]byte{1, 7, 200, 33, 99}, 4)
+
+	// The expected hashes were verified with crypt(3) of libxcrypt.
+	tests := []struct {
+		name   string
+		hasher cloudinit.PasswordHasher
+		want   string
+	}{
+		{
+			name:   "sha512",
+			hasher: cloudinit.SHA512Crypt{},
+			want: "$6$/56VX/56VX/56VX/$vB/Hs17XlbWaB/LFKh5BYU/Wl/tvHuY7pFBZLDUoakHma78Ru3hC6iSCwwIqQLqZnPu6kc/" +
+				"6d10455Q45aHAl1",
+		},
+		{
+			name:   "sha256 rounds",
+			hasher: cloudinit.SHA256Crypt{Rounds: 10000},
+			want:   "$5$rounds=10000$/56VX/56VX/56VX/$yggxH5JB7Bo5r.1O3a.fyUwK3TjetI0oPGWLZDdKi6A",
+		},
+		{
+			name:   "yescrypt",
+			hasher: cloudinit.Yescrypt{},
+			want:   "$y$j9T$/Q.mVAK.5UQ6X3k/65mM/.$qSCDjbHlctpVifHsUHeol6wisNbwS6BlI5wIoBBdKL1",
+		},
+		{
+			name:   "yescrypt cost",
+			hasher: cloudinit.Yescrypt{Cost: 10},
+			want:   "$y$j7T$/Q.mVAK.5UQ6X3k/65mM/.$nGSS7tqY64N.Cr/SoT0hVNYDKGKf2pvD/jllh/Wmx28",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("password", bytes.NewReader(random))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hash)
+		})
+	}
+
+	t.Run("bcrypt", func(t *testing.T) {
+		hash, err := cloudinit.Bcrypt{Cost: bcrypt.MinCost}.Hash("password", bytes.NewReader(random))
+		require.NoError(t, err)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("password")))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := cloudinit.SHA512Crypt{Rounds: 10}.Hash("password", bytes.NewReader(random))
+		require.Error(t, err)
+
+		_, err = cloudinit.Yescrypt{}.Hash("password", iotest.ErrReader(errors.New("no entropy")))
+		require.Error(t, err)
+	})
+}
+
+func TestConfigPasswordHasher(t *testing.T) {
+	userPassword := func(c *cloudinit.Config) string {
+		require.NoError(t, c.AddUser(cloudinit.User{Name: "test", Password: "test123"}))
+
+		var cc cloudinit.CloudConfig
+		require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+		require.Len(t, cc.Users, 1)
+
+		return cc.Users[0].Password
+	}
+
+	c := cloudinit.NewConfig()
+	assert.Regexp(t, `^\$6\$`, userPassword(c))
+
+	c = cloudinit.NewConfig()
+	c.SetDistro(cloudinit.DistroFedora)
+	assert.Regexp(t, `^\$y\$j9T\$`, userPassword(c))
+
+	c = cloudinit.NewConfig()
+	c.SetDistro(cloudinit.DistroFedora)
+	c.SetPasswordHasher(cloudinit.SHA256Crypt{})
+	assert.Regexp(t, `^\$5\$`, userPassword(c))
+
+	c = cloudinit.NewConfig()
+	c.SetRandom(iotest.ErrReader(errors.New("no entropy")))
+	require.Error(t, c.AddUser(cloudinit.User{Name: "test", Password: "test123"}))
+}
+
+func TestConfigSetPassword(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetPasswordHasher(cloudinit.Bcrypt{Cost: bcrypt.MinCost})
+
+	require.NoError(t, c.SetRootPassword("first"))
+	require.NoError(t, c.SetRootPassword("rootpassword"))
+	require.NoError(t, c.SetPassword("admin", "$6$salt$hash"))
+
+	random, err := c.SetRandomPassword("operator")
+	require.NoError(t, err)
+	assert.Len(t, random, 20)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+
+	assert.False(t, cc.PasswordChange.Expire)
+	assert.Empty(t, cc.PasswordChange.List)
+	require.Len(t, cc.PasswordChange.Users, 3)
+
+	root := cc.PasswordChange.Users[0]
+	assert.Equal(t, "root", root.Name)
+	assert.Equal(t, cloudinit.PasswordTypeHash, root.Type)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(root.Password), []byte("rootpassword")))
+
+	assert.Equal(t, cloudinit.PasswordChangeUser{Name: "admin", Password: "$6$salt$hash", Type: cloudinit.PasswordTypeHash},
+		cc.PasswordChange.Users[1])
+
+	operator := cc.PasswordChange.Users[2]
+	assert.Equal(t, "operator", operator.Name)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(operator.Password), []byte(random)))
+}
@@ -0,0 +1,224 @@
+package cloudinit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidPowerState is returned for invalid power_state settings.
+var ErrInvalidPowerState = errors.New("invalid power state")
+
+// ErrInvalidPhoneHome is returned for invalid phone_home settings.
+var ErrInvalidPhoneHome = errors.New("invalid phone home")
+
+// powerStateDelayRe matches the delays accepted by shutdown, "now", "+5" or "5".
+var powerStateDelayRe = regexp.MustCompile(`^(now|\+?[0-9]+)$`)
+
+// PowerStateMode is the action of the power_state_change module.
+type PowerStateMode string
+
+const (
+	PowerStatePoweroff PowerStateMode = "poweroff"
+	PowerStateReboot   PowerStateMode = "reboot"
+	PowerStateHalt     PowerStateMode = "halt"
+)
+
+// PowerState holds the configuration of the power_state_change module, run
+// at the end of the final stage.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#power-state-change
+type PowerState struct {
+	// Mode is the action to take.
+	Mode PowerStateMode `yaml:"mode"`
+	// Delay is the delay passed to shutdown, "now" or "+N" minutes.
+	Delay string `yaml:"delay,omitempty"`
+	// Message is broadcast to the logged in users.
+	Message string `yaml:"message,omitempty"`
+	// Timeout is the time in seconds to wait for cloud-init to finish, 30 by default.
+	Timeout int `yaml:"timeout,omitempty"`
+	// Condition is a command whose zero exit status allows the action.
+	Condition *Command `yaml:"condition,omitempty"`
+}
+
+// Validate checks the mode, the delay and the timeout.
+func (p *PowerState) Validate() error {
+	switch p.Mode {
+	case PowerStatePoweroff, PowerStateReboot, PowerStateHalt:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidPowerState, p.Mode)
+	}
+
+	if p.Delay != "" && !powerStateDelayRe.MatchString(p.Delay) {
+		return fmt.Errorf("%w: invalid delay %q", ErrInvalidPowerState, p.Delay)
+	}
+
+	if p.Timeout < 0 {
+		return fmt.Errorf("%w: negative timeout", ErrInvalidPowerState)
+	}
+
+	return nil
+}
+
+// Fields posted by the phone_home module.
+const (
+	PhoneHomeAll           = "all"
+	PhoneHomePubKeyRSA     = "pub_key_rsa"
+	PhoneHomePubKeyECDSA   = "pub_key_ecdsa"
+	PhoneHomePubKeyEd25519 = "pub_key_ed25519"
+	PhoneHomeInstanceID    = "instance_id"
+	PhoneHomeHostname      = "hostname"
+	PhoneHomeFQDN          = "fqdn"
+)
+
+// PhoneHomePost are the fields posted by the phone_home module.
+type PhoneHomePost []string
+
+// MarshalYAML writes "all" as a string, as cloud-init does not accept it in a list.
+func (p PhoneHomePost) MarshalYAML() (interface{}, error) {
+	if len(p) == 1 && p[0] == PhoneHomeAll {
+		return PhoneHomeAll, nil
+	}
+
+	return []string(p), nil
+}
+
+// UnmarshalYAML accepts "all" or a list of fields.
+func (p *PhoneHomePost) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = PhoneHomePost{node.Value}
+		return nil
+	}
+
+	var fields []string
+	if err := node.Decode(&fields); err != nil {
+		return fmt.Errorf("line %d: invalid phone_home post: %w", node.Line, err)
+	}
+
+	*p = fields
+
+	return nil
+}
+
+// PhoneHome holds the configuration of the phone_home module, which posts
+// the host keys and the instance data to URL at the end of the final stage.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#phone-home
+type PhoneHome struct {
+	// URL is the URL to post to. cloud-init replaces $INSTANCE_ID in it.
+	URL string `yaml:"url"`
+	// Post are the posted fields, all of them when empty.
+	Post PhoneHomePost `yaml:"post,omitempty"`
+	// Tries is the number of attempts, 10 by default.
+	Tries int `yaml:"tries,omitempty"`
+}
+
+// Validate checks the URL and the posted fields.
+func (p *PhoneHome) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: invalid URL %q", ErrInvalidPhoneHome, p.URL)
+	}
+
+	for _, field := range p.Post {
+		if !slices.Contains([]string{
+			PhoneHomeAll, PhoneHomePubKeyRSA, PhoneHomePubKeyECDSA, PhoneHomePubKeyEd25519,
+			PhoneHomeInstanceID, PhoneHomeHostname, PhoneHomeFQDN,
+		}, field) {
+			return fmt.Errorf("%w: unknown field %q", ErrInvalidPhoneHome, field)
+		}
+	}
+
+	if p.Tries < 0 {
+		return fmt.Errorf("%w: negative tries", ErrInvalidPhoneHome)
+	}
+
+	return nil
+}
+
+// PhoneHomeData is the data of the URL template of SetPhoneHome. The values
+// are escaped with url.PathEscape.
+type PhoneHomeData struct {
+	// InstanceID is the instance ID of the Config.
+	InstanceID string
+	// Hostname is the short hostname of the FQDN.
+	Hostname string
+	// FQDN is the FQDN of the Config.
+	FQDN string
+}
+
+// SetPhoneHome sets the phone_home module. The URL is a text/template
+// executed with PhoneHomeData when the user-data is generated, e.g.
+// "https://api.example.com/phone-home/{{.InstanceID}}", so it follows
+// SetInstanceID, RotateInstanceID and SetFQDN. Receiver takes the instance ID
+// from the last path element, so it has to end the path when the posted
+// fields do not include instance_id.
+func (c *Config) SetPhoneHome(ph PhoneHome) error {
+	tmpl, err := template.New("phone_home").Parse(ph.URL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid URL template: %w", ErrInvalidPhoneHome, err)
+	}
+
+	prev, prevURL := c.phoneHome, c.phoneHomeURL
+	c.phoneHome, c.phoneHomeURL = &ph, tmpl
+
+	rendered, err := c.renderPhoneHome()
+	if err == nil {
+		err = rendered.Validate()
+	}
+
+	if err != nil {
+		c.phoneHome, c.phoneHomeURL = prev, prevURL
+		return err
+	}
+
+	return nil
+}
+
+// renderPhoneHome returns the phone_home section with the URL template executed.
+func (c *Config) renderPhoneHome() (*PhoneHome, error) {
+	id, err := c.InstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := c.phoneHomeURL.Execute(buf, PhoneHomeData{
+		InstanceID: url.PathEscape(id),
+		Hostname:   url.PathEscape(c.Hostname()),
+		FQDN:       url.PathEscape(c.fqdn),
+	}); err != nil {
+		return nil, fmt.Errorf("%w: failed to execute URL template: %w", ErrInvalidPhoneHome, err)
+	}
+
+	ph := *c.phoneHome
+	ph.URL = buf.String()
+
+	return &ph, nil
+}
+
+// applyPhoneHome writes the phone_home section. The URL is validated again,
+// as the instance ID or the FQDN may have changed since SetPhoneHome. A
+// Config read from a seed keeps the rendered URL in its cloud-config.
+func (c *Config) applyPhoneHome(cc *CloudConfig) error {
+	if c.phoneHome == nil {
+		return nil
+	}
+
+	ph, err := c.renderPhoneHome()
+	if err != nil {
+		return err
+	}
+
+	if err := ph.Validate(); err != nil {
+		return err
+	}
+
+	cc.PhoneHome = ph
+
+	return nil
+}
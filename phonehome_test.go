@@ -0,0 +1,80 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPowerStateAndFinalMessage(t *testing.T) {
+	condition := cloudinit.ExecCommand("test", "-f", "/run/provisioned")
+	ps := &cloudinit.PowerState{Mode: cloudinit.PowerStateReboot, Delay: "+1", Timeout: 120, Condition: &condition}
+	require.NoError(t, ps.Validate())
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{PowerState: ps, FinalMessage: "ready after $UPTIME seconds"})
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	assert.Equal(t, ps, cc.PowerState)
+	assert.Equal(t, "ready after $UPTIME seconds", cc.FinalMessage)
+
+	require.ErrorIs(t, (&cloudinit.PowerState{Mode: "suspend"}).Validate(), cloudinit.ErrInvalidPowerState)
+	require.ErrorIs(t, (&cloudinit.PowerState{Mode: cloudinit.PowerStateHalt, Delay: "soon"}).Validate(),
+		cloudinit.ErrInvalidPowerState)
+}
+
+func TestSetPhoneHome(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetFQDN("web1.example.com")
+	require.NoError(t, c.SetInstanceID("iid-1"))
+	require.NoError(t, c.SetPhoneHome(cloudinit.PhoneHome{
+		URL:   "https://api.example.com/phone-home/{{.InstanceID}}?host={{.Hostname}}",
+		Post:  cloudinit.PhoneHomePost{cloudinit.PhoneHomeInstanceID, cloudinit.PhoneHomeFQDN},
+		Tries: 3,
+	}))
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	assert.Equal(t, &cloudinit.PhoneHome{
+		URL:   "https://api.example.com/phone-home/iid-1?host=web1",
+		Post:  cloudinit.PhoneHomePost{"instance_id", "fqdn"},
+		Tries: 3,
+	}, cc.PhoneHome)
+
+	rotated, err := c.RotateInstanceID()
+	require.NoError(t, err)
+	assert.Contains(t, string(c.GenerateConfigContent()), "/phone-home/"+rotated+"?")
+
+	require.NoError(t, c.SetInstanceID("iid-2?admin=1#x"))
+	assert.Contains(t, string(c.GenerateConfigContent()), "/phone-home/iid-2%3Fadmin=1%23x?host=web1\n")
+
+	require.NoError(t, c.SetPhoneHome(cloudinit.PhoneHome{URL: "http://10.0.0.1/$INSTANCE_ID", Post: cloudinit.PhoneHomePost{"all"}}))
+	assert.Contains(t, string(c.GenerateConfigContent()), "phone_home:\n    url: http://10.0.0.1/$INSTANCE_ID\n    post: all\n")
+
+	for _, ph := range []cloudinit.PhoneHome{
+		{URL: "https://api.example.com/{{.Missing}}"},
+		{URL: "https://api.example.com/{{"},
+		{URL: "ftp://api.example.com/"},
+		{URL: "https://api.example.com/", Post: cloudinit.PhoneHomePost{"password"}},
+	} {
+		require.ErrorIs(t, c.SetPhoneHome(ph), cloudinit.ErrInvalidPhoneHome, ph.URL)
+	}
+
+	assert.Contains(t, string(c.GenerateConfigContent()), "url: http://10.0.0.1/$INSTANCE_ID\n")
+}
+
+func TestPhoneHomeRevalidated(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetFQDN("web1.example.com")
+	require.NoError(t, c.SetPhoneHome(cloudinit.PhoneHome{URL: "https://{{.Hostname}}.nodes.example.com/ready"}))
+	assert.Contains(t, string(c.GenerateConfigContent()), "url: https://web1.nodes.example.com/ready\n")
+
+	c.SetFQDN("web 1.example.com")
+	assert.Nil(t, c.GenerateConfigContent())
+	require.ErrorIs(t, c.WriteISO(new(bytes.Buffer)), cloudinit.ErrInvalidPhoneHome)
+}
@@ -0,0 +1,374 @@
+package cloudinit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+// receiverMaxBody is the size limit of a request body, reporting events may carry log files.
+const receiverMaxBody = 10 << 20
+
+// receiverFinalStage is the reporting event of the last cloud-init stage.
+const receiverFinalStage = "modules-final"
+
+// receiverTokenParam is the query parameter carrying the token of the instance.
+const receiverTokenParam = "token"
+
+// Errors of the requests rejected by Receiver.
+var (
+	errReceiverUnknownInstance = errors.New("unknown instance")
+	errReceiverInvalidToken    = errors.New("invalid token")
+)
+
+// ReceiverEventType is the kind of a request received by Receiver.
+type ReceiverEventType string
+
+const (
+	// ReceiverPhoneHome is a post of the phone_home module.
+	ReceiverPhoneHome ReceiverEventType = "phone_home"
+	// ReceiverStart is a start event of the webhook reporter.
+	ReceiverStart ReceiverEventType = "start"
+	// ReceiverFinish is a finish event of the webhook reporter.
+	ReceiverFinish ReceiverEventType = "finish"
+)
+
+// Results of the finish events.
+const (
+	ReportResultSuccess = "SUCCESS"
+	ReportResultWarn    = "WARN"
+	ReportResultFail    = "FAIL"
+)
+
+// ReceiverEvent is a phone_home post or a reporting event of an instance.
+type ReceiverEvent struct {
+	// InstanceID is the instance the event belongs to.
+	InstanceID string
+	// Type is the kind of the event.
+	Type ReceiverEventType
+	// Name is the stage or module of a reporting event, e.g. "modules-final/config-phone-home".
+	Name string
+	// Description is the description of a reporting event.
+	Description string
+	// Result is the result of a finish event, SUCCESS, WARN or FAIL.
+	Result string
+	// Time is the time of the event on the guest, or the receive time of a phone_home post.
+	Time time.Time
+	// PhoneHome are the posted fields of a phone_home post, e.g. "pub_key_ed25519" and "fqdn".
+	PhoneHome map[string]string
+}
+
+// ProvisioningState is the provisioning progress of an instance.
+type ProvisioningState string
+
+const (
+	// ProvisioningPending is the state before the first event.
+	ProvisioningPending ProvisioningState = "pending"
+	// ProvisioningRunning is the state after the first event.
+	ProvisioningRunning ProvisioningState = "running"
+	// ProvisioningFinished is the state after the final stage finished without failures.
+	ProvisioningFinished ProvisioningState = "finished"
+	// ProvisioningFailed is the state after the final stage finished with failures.
+	ProvisioningFailed ProvisioningState = "failed"
+)
+
+// InstanceStatus is the provisioning status of an instance tracked by Receiver.
+type InstanceStatus struct {
+	// InstanceID is the instance ID.
+	InstanceID string
+	// State is the provisioning progress.
+	State ProvisioningState
+	// Started is the time of the first event.
+	Started time.Time
+	// Finished is the time the final stage finished.
+	Finished time.Time
+	// Failures are the names of the stages and modules that failed.
+	Failures []string
+	// Warnings are the names of the stages and modules that finished with warnings.
+	Warnings []string
+	// PhoneHome are the fields of the last phone_home post.
+	PhoneHome map[string]string
+}
+
+// Receiver is an http.Handler receiving the phone_home posts and the
+// reporting webhook events of the instances it tracks. The instance ID is
+// the last path element of the URL, e.g. https://example.com/cloud-init/<id>,
+// or the instance_id field of a phone_home post. Every request has to carry
+// the token returned by Track in the token query parameter, e.g.
+// https://example.com/cloud-init/<id>?token=<token>, as neither phone_home
+// nor the webhook reporter can send other credentials to every endpoint.
+type Receiver struct {
+	mu        sync.Mutex
+	instances map[string]*InstanceStatus
+	tokens    map[string]string
+	callbacks []func(ReceiverEvent)
+	subs      map[chan ReceiverEvent]struct{}
+	now       func() time.Time
+}
+
+// NewReceiver returns a Receiver without tracked instances.
+func NewReceiver() *Receiver {
+	return &Receiver{
+		instances: make(map[string]*InstanceStatus),
+		tokens:    make(map[string]string),
+		subs:      make(map[chan ReceiverEvent]struct{}),
+		now:       time.Now,
+	}
+}
+
+// Track starts tracking an instance, e.g. Config.InstanceID(), and returns
+// the random token its requests have to carry. Tracking an instance again
+// keeps its status and returns its token. Requests of instances that are not
+// tracked are rejected.
+func (r *Receiver) Track(instanceID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if token, ok := r.tokens[instanceID]; ok {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	r.tokens[instanceID] = token
+	r.instances[instanceID] = &InstanceStatus{InstanceID: instanceID, State: ProvisioningPending}
+
+	return token, nil
+}
+
+// Untrack stops tracking an instance.
+func (r *Receiver) Untrack(instanceID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.instances, instanceID)
+	delete(r.tokens, instanceID)
+}
+
+// Status returns the provisioning status of a tracked instance.
+func (r *Receiver) Status(instanceID string) (InstanceStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status, ok := r.instances[instanceID]
+	if !ok {
+		return InstanceStatus{}, false
+	}
+
+	return status.clone(), true
+}
+
+// OnEvent registers a callback called with every event after the status was
+// updated. Callbacks run in the goroutine of the request, in order.
+func (r *Receiver) OnEvent(fn func(ReceiverEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.callbacks = append(r.callbacks, fn)
+}
+
+// Subscribe returns a channel receiving every event, and a function that
+// cancels the subscription and closes the channel. Events are dropped while
+// the buffer of the channel is full, so a slow reader never blocks a guest.
+func (r *Receiver) Subscribe(buffer int) (<-chan ReceiverEvent, func()) {
+	ch := make(chan ReceiverEvent, buffer)
+
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.subs, ch)
+			r.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// ServeHTTP handles a phone_home post or a reporting event.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, receiverMaxBody)
+
+	event, err := r.parseEvent(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err := r.record(event, req.URL.Query().Get(receiverTokenParam)); {
+	case errors.Is(err, errReceiverUnknownInstance):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errReceiverInvalidToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// reportingEvent is the JSON body posted by the webhook reporter.
+//
+//nolint:tagliatelle // This format is required by the cloud-init reporting.
+type reportingEvent struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	EventType   string  `json:"event_type"`
+	Origin      string  `json:"origin"`
+	Timestamp   float64 `json:"timestamp"`
+	Result      string  `json:"result"`
+}
+
+// parseEvent tells the requests apart by their body, as neither the webhook
+// reporter nor old phone_home releases set a Content-Type. The reporter posts
+// a JSON object, phone_home form-encoded fields.
+func (r *Receiver) parseEvent(req *http.Request) (ReceiverEvent, error) {
+	event := ReceiverEvent{InstanceID: path.Base(req.URL.Path)}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return event, errors.New("failed to read the request body")
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var re reportingEvent
+		if err := json.Unmarshal(body, &re); err != nil {
+			return event, errors.New("invalid reporting event")
+		}
+
+		switch ReceiverEventType(re.EventType) {
+		case ReceiverStart, ReceiverFinish:
+		default:
+			return event, errors.New("unknown reporting event type")
+		}
+
+		sec, frac := math.Modf(re.Timestamp)
+		event.Type = ReceiverEventType(re.EventType)
+		event.Name, event.Description, event.Result = re.Name, re.Description, re.Result
+		event.Time = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+
+		return event, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return event, errors.New("invalid phone_home post")
+	}
+
+	event.Type = ReceiverPhoneHome
+	event.Time = r.now().UTC()
+	event.PhoneHome = make(map[string]string, len(form))
+
+	for key := range form {
+		event.PhoneHome[key] = form.Get(key)
+	}
+
+	if id := event.PhoneHome["instance_id"]; id != "" {
+		event.InstanceID = id
+	}
+
+	return event, nil
+}
+
+// record checks the token, updates the status of the instance and publishes
+// the event.
+func (r *Receiver) record(event ReceiverEvent, token string) error {
+	r.mu.Lock()
+
+	status, ok := r.instances[event.InstanceID]
+	if !ok {
+		r.mu.Unlock()
+		return errReceiverUnknownInstance
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.tokens[event.InstanceID])) != 1 {
+		r.mu.Unlock()
+		return errReceiverInvalidToken
+	}
+
+	status.update(event)
+
+	callbacks := slices.Clone(r.callbacks)
+	for ch := range r.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	r.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(event)
+	}
+
+	return nil
+}
+
+func (s *InstanceStatus) update(event ReceiverEvent) {
+	if s.State == ProvisioningPending {
+		s.State = ProvisioningRunning
+		s.Started = event.Time
+	}
+
+	switch event.Type {
+	case ReceiverPhoneHome:
+		s.PhoneHome = event.PhoneHome
+	case ReceiverFinish:
+		switch event.Result {
+		case ReportResultFail:
+			s.Failures = append(s.Failures, event.Name)
+		case ReportResultWarn:
+			s.Warnings = append(s.Warnings, event.Name)
+		}
+
+		if event.Name == receiverFinalStage {
+			s.Finished = event.Time
+			s.State = ProvisioningFinished
+
+			if len(s.Failures) > 0 {
+				s.State = ProvisioningFailed
+			}
+		}
+	case ReceiverStart:
+		if event.Name == "init-local" && s.State != ProvisioningRunning {
+			// The guest rebooted into a new provisioning run.
+			*s = InstanceStatus{InstanceID: s.InstanceID, State: ProvisioningRunning, Started: event.Time}
+		}
+	}
+}
+
+func (s *InstanceStatus) clone() InstanceStatus {
+	c := *s
+	c.Failures = slices.Clone(s.Failures)
+	c.Warnings = slices.Clone(s.Warnings)
+	c.PhoneHome = maps.Clone(s.PhoneHome)
+
+	return c
+}
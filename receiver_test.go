@@ -0,0 +1,101 @@
+package cloudinit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestReceiver(t *testing.T) {
+	r := cloudinit.NewReceiver()
+	token, err := r.Track("iid-1")
+	require.NoError(t, err)
+
+	again, err := r.Track("iid-1")
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+
+	other, err := r.Track("iid-2")
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	var names []string
+	r.OnEvent(func(e cloudinit.ReceiverEvent) { names = append(names, e.Name) })
+
+	events, cancel := r.Subscribe(10)
+	defer cancel()
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// The webhook reporter posts without a Content-Type.
+	post := func(path, contentType, body string) int {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body)) //nolint:noctx // Test server.
+		require.NoError(t, err)
+
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	status, ok := r.Status("iid-1")
+	require.True(t, ok)
+	assert.Equal(t, cloudinit.ProvisioningPending, status.State)
+
+	for _, body := range []string{
+		`{"name": "init-local", "event_type": "start", "origin": "cloudinit", "timestamp": 1714564800.5}`,
+		`{"name": "modules-config/config-apt-configure", "event_type": "finish", "result": "FAIL", "timestamp": 1714564810}`,
+		`{"name": "modules-final", "event_type": "finish", "result": "SUCCESS", "timestamp": 1714564830}`,
+	} {
+		assert.Equal(t, http.StatusNoContent, post("/cloud-init/iid-1?token="+token, "", body))
+	}
+
+	form := url.Values{"instance_id": {"iid-1"}, "fqdn": {"web1.example.com"}, "pub_key_ed25519": {"ssh-ed25519 AAAA"}}
+	assert.Equal(t, http.StatusNoContent, post("/phone-home?token="+token, "application/x-www-form-urlencoded", form.Encode()))
+
+	status, ok = r.Status("iid-1")
+	require.True(t, ok)
+	assert.Equal(t, cloudinit.ProvisioningFailed, status.State)
+	assert.Equal(t, []string{"modules-config/config-apt-configure"}, status.Failures)
+	assert.Equal(t, "2024-05-01T12:00:00.5Z", status.Started.Format("2006-01-02T15:04:05.999Z07:00"))
+	assert.Equal(t, "web1.example.com", status.PhoneHome["fqdn"])
+
+	assert.Equal(t, []string{"init-local", "modules-config/config-apt-configure", "modules-final", ""}, names)
+	require.Len(t, events, 4)
+	first := <-events
+	assert.Equal(t, cloudinit.ReceiverStart, first.Type)
+	assert.Equal(t, "iid-1", first.InstanceID)
+
+	assert.Equal(t, http.StatusNotFound, post("/cloud-init/iid-3?token="+token, "",
+		`{"name": "init-local", "event_type": "start"}`))
+	assert.Equal(t, http.StatusBadRequest, post("/cloud-init/iid-1?token="+token, "", `{"event_type": "progress"}`))
+
+	// Requests without the token of the instance are rejected.
+	for _, path := range []string{"/cloud-init/iid-1", "/cloud-init/iid-1?token=" + other, "/cloud-init/iid-2?token=" + token} {
+		assert.Equal(t, http.StatusForbidden, post(path, "", `{"name": "modules-final", "event_type": "finish", "result": "SUCCESS"}`))
+	}
+
+	status, ok = r.Status("iid-2")
+	require.True(t, ok)
+	assert.Equal(t, cloudinit.ProvisioningPending, status.State)
+
+	r.Untrack("iid-1")
+	assert.Equal(t, http.StatusNotFound, post("/cloud-init/iid-1?token="+token, "", `{"name": "init-local", "event_type": "start"}`))
+
+	resp, err := http.Get(srv.URL + "/cloud-init/iid-1") //nolint:noctx // Test server.
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
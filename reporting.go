@@ -0,0 +1,186 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidReporting is returned for invalid reporting and output settings.
+var ErrInvalidReporting = errors.New("invalid reporting configuration")
+
+// ReportingType is the type of a reporting handler.
+type ReportingType string
+
+const (
+	// ReportingLog logs the events with the cloud-init logger.
+	ReportingLog ReportingType = "log"
+	// ReportingPrint prints the events to stdout.
+	ReportingPrint ReportingType = "print"
+	// ReportingWebhook posts the events as JSON to an endpoint.
+	ReportingWebhook ReportingType = "webhook"
+)
+
+// ReportingHandler is a handler of the cloud-init reporting events, e.g. a
+// webhook posting to a Receiver.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/reporting.html
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type ReportingHandler struct {
+	// Type is the type of the handler.
+	Type ReportingType `yaml:"type"`
+	// Level is the log level of a log handler, DEBUG by default.
+	Level string `yaml:"level,omitempty"`
+	// Endpoint is the URL a webhook handler posts to.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// ConsumerKey is the OAuth 1.0 consumer key of a webhook handler.
+	ConsumerKey string `yaml:"consumer_key,omitempty"`
+	// ConsumerSecret is the OAuth 1.0 consumer secret of a webhook handler.
+	ConsumerSecret string `yaml:"consumer_secret,omitempty"`
+	// TokenKey is the OAuth 1.0 token key of a webhook handler.
+	TokenKey string `yaml:"token_key,omitempty"`
+	// TokenSecret is the OAuth 1.0 token secret of a webhook handler.
+	TokenSecret string `yaml:"token_secret,omitempty"`
+	// Timeout is the timeout of a webhook request in seconds.
+	Timeout int `yaml:"timeout,omitempty"`
+	// Retries is the number of retries of a failed webhook request.
+	Retries int `yaml:"retries,omitempty"`
+}
+
+// Validate checks the settings of the handler type.
+func (h ReportingHandler) Validate() error {
+	switch h.Type {
+	case ReportingLog:
+		switch h.Level {
+		case "", "DEBUG", "INFO", "WARN", "WARNING", "ERROR", "CRITICAL":
+		default:
+			return fmt.Errorf("%w: unknown log level %q", ErrInvalidReporting, h.Level)
+		}
+	case ReportingPrint:
+	case ReportingWebhook:
+		u, err := url.Parse(h.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: invalid endpoint %q", ErrInvalidReporting, h.Endpoint)
+		}
+
+		if (h.ConsumerSecret != "" && h.ConsumerKey == "") || (h.TokenSecret != "" && h.TokenKey == "") {
+			return fmt.Errorf("%w: OAuth secret without key", ErrInvalidReporting)
+		}
+
+		if h.Timeout < 0 || h.Retries < 0 {
+			return fmt.Errorf("%w: negative timeout or retries", ErrInvalidReporting)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown handler type %q", ErrInvalidReporting, h.Type)
+	}
+
+	if h.Endpoint != "" || h.ConsumerKey != "" || h.TokenKey != "" {
+		return fmt.Errorf("%w: webhook settings on a %s handler", ErrInvalidReporting, h.Type)
+	}
+
+	return nil
+}
+
+// ValidateReporting checks the handlers of the reporting section.
+func ValidateReporting(handlers map[string]ReportingHandler) error {
+	for _, name := range slices.Sorted(maps.Keys(handlers)) {
+		if err := handlers[name].Validate(); err != nil {
+			return fmt.Errorf("reporting handler %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// OutputTarget is where the stdout and stderr of a cloud-init stage go,
+// e.g. "| tee -a /var/log/cloud-init-output.log" or ">> /var/log/boot.log".
+type OutputTarget struct {
+	// Stdout is the target of the standard output.
+	Stdout string
+	// Stderr is the target of the standard error, the same as Stdout when empty.
+	// "&1" redirects it to the standard output.
+	Stderr string
+}
+
+// MarshalYAML writes the target, or a [stdout, stderr] pair when they differ.
+func (o OutputTarget) MarshalYAML() (interface{}, error) {
+	if o.Stderr == "" {
+		return o.Stdout, nil
+	}
+
+	return []string{o.Stdout, o.Stderr}, nil
+}
+
+// UnmarshalYAML accepts a target, a [stdout, stderr] pair, or a mapping of output and error.
+func (o *OutputTarget) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*o = OutputTarget{Stdout: node.Value}
+	case yaml.SequenceNode:
+		var pair []string
+		if err := node.Decode(&pair); err != nil || len(pair) == 0 || len(pair) > 2 {
+			return fmt.Errorf("line %d: output must be a [stdout, stderr] pair", node.Line)
+		}
+
+		*o = OutputTarget{Stdout: pair[0]}
+		if len(pair) == 2 {
+			o.Stderr = pair[1]
+		}
+	case yaml.MappingNode:
+		var m struct {
+			Output string `yaml:"output"`
+			Error  string `yaml:"error"`
+		}
+		if err := node.Decode(&m); err != nil {
+			return fmt.Errorf("line %d: invalid output: %w", node.Line, err)
+		}
+
+		*o = OutputTarget{Stdout: m.Output, Stderr: m.Error}
+	default:
+		return fmt.Errorf("line %d: invalid output", node.Line)
+	}
+
+	return nil
+}
+
+// OutputConfig redirects the output of the cloud-init stages.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/base_config_reference.html#output
+type OutputConfig struct {
+	// All is the target of all stages without their own.
+	All *OutputTarget `yaml:"all,omitempty"`
+	// Init is the target of the init stage.
+	Init *OutputTarget `yaml:"init,omitempty"`
+	// Config is the target of the config stage.
+	Config *OutputTarget `yaml:"config,omitempty"`
+	// Final is the target of the final stage.
+	Final *OutputTarget `yaml:"final,omitempty"`
+}
+
+// Validate checks that every target is a pipe, a file redirection or "&1".
+func (o *OutputConfig) Validate() error {
+	for _, target := range []*OutputTarget{o.All, o.Init, o.Config, o.Final} {
+		if target == nil {
+			continue
+		}
+
+		for _, t := range []string{target.Stdout, target.Stderr} {
+			t = strings.TrimSpace(t)
+			if t != "" && t != "&1" && !strings.HasPrefix(t, "|") && !strings.HasPrefix(t, ">") {
+				return fmt.Errorf("%w: output %q is not a pipe or redirection", ErrInvalidReporting, t)
+			}
+		}
+
+		if strings.TrimSpace(target.Stdout) == "" {
+			return fmt.Errorf("%w: empty output", ErrInvalidReporting)
+		}
+	}
+
+	return nil
+}
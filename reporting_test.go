@@ -0,0 +1,148 @@
+package cloudinit_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestReportingAndOutput(t *testing.T) {
+	c := cloudinit.NewConfig()
+	instanceID, err := c.InstanceID()
+	require.NoError(t, err)
+
+	reporting := map[string]cloudinit.ReportingHandler{
+		"platform": {
+			Type:           cloudinit.ReportingWebhook,
+			Endpoint:       "https://provision.example.com/cloud-init/" + instanceID,
+			ConsumerKey:    "key",
+			ConsumerSecret: "secret",
+			Retries:        3,
+		},
+		"log": {Type: cloudinit.ReportingLog, Level: "WARN"},
+	}
+	output := &cloudinit.OutputConfig{
+		All:   &cloudinit.OutputTarget{Stdout: "| tee -a /var/log/cloud-init-output.log"},
+		Final: &cloudinit.OutputTarget{Stdout: ">> /var/log/final.log", Stderr: "&1"},
+	}
+	require.NoError(t, cloudinit.ValidateReporting(reporting))
+	require.NoError(t, output.Validate())
+
+	c.SetVendorConfig(&cloudinit.CloudConfig{Reporting: reporting, Output: output})
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		Timezone:  "UTC",
+		Reporting: map[string]cloudinit.ReportingHandler{"user": {Type: cloudinit.ReportingPrint}},
+		Output:    &cloudinit.OutputConfig{All: &cloudinit.OutputTarget{Stdout: "> /dev/null"}},
+	})
+
+	userData := string(c.GenerateConfigContent())
+	assert.Equal(t, "#cloud-config\ntimezone: UTC\n", userData)
+
+	content := c.GenerateVendorDataContent()
+	assert.Contains(t, string(content), "output:\n    all: '| tee -a /var/log/cloud-init-output.log'\n    final:\n        - '>> /var/log/final.log'\n        - '&1'\n")
+
+	// The reporting handlers of the user cloud-config are moved to
+	// vendor-data, the vendor settings take precedence.
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, cloudinit.ReportingHandler{Type: cloudinit.ReportingPrint}, cc.Reporting["user"])
+	delete(cc.Reporting, "user")
+	assert.Equal(t, reporting, cc.Reporting)
+	assert.Equal(t, output, cc.Output)
+
+	require.NoError(t, yaml.Unmarshal([]byte("output: {init: {output: '> /dev/null', error: '&1'}}"), &cc))
+	assert.Equal(t, &cloudinit.OutputTarget{Stdout: "> /dev/null", Stderr: "&1"}, cc.Output.Init)
+
+	for _, h := range []cloudinit.ReportingHandler{
+		{Type: "hyperv-kvp"},
+		{Type: cloudinit.ReportingWebhook, Endpoint: "/relative"},
+		{Type: cloudinit.ReportingWebhook, Endpoint: "https://example.com", ConsumerSecret: "secret"},
+		{Type: cloudinit.ReportingLog, Endpoint: "https://example.com"},
+	} {
+		require.ErrorIs(t, h.Validate(), cloudinit.ErrInvalidReporting, h.Type)
+	}
+
+	require.ErrorIs(t, (&cloudinit.OutputConfig{All: &cloudinit.OutputTarget{Stdout: "/var/log/x"}}).Validate(),
+		cloudinit.ErrInvalidReporting)
+}
+
+func TestVendorDataSeeds(t *testing.T) {
+	vendor := &cloudinit.CloudConfig{
+		Reporting: map[string]cloudinit.ReportingHandler{
+			"platform": {Type: cloudinit.ReportingWebhook, Endpoint: "https://provision.example.com/cloud-init/iid-1"},
+		},
+	}
+
+	for _, newFunc := range []func() *cloudinit.Config{cloudinit.NewConfig, cloudinit.NewConfigDriveConfig} {
+		c := newFunc()
+		c.SetVendorConfig(vendor)
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, c.WriteISO(buf))
+
+		seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.NotContains(t, string(seed.UserData), "provision.example.com", seed.DataSource)
+		assert.Contains(t, string(seed.VendorData), "provision.example.com", seed.DataSource)
+
+		restored, err := seed.Config()
+		require.NoError(t, err)
+		assert.Equal(t, c.GenerateVendorDataContent(), restored.GenerateVendorDataContent(), seed.DataSource)
+	}
+
+	configDrive := cloudinit.NewConfigDriveConfig()
+	configDrive.SetVendorConfig(vendor)
+
+	files := make(map[string][]byte)
+	buf := new(bytes.Buffer)
+	require.NoError(t, configDrive.WriteTar(buf))
+
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		files[hdr.Name], err = io.ReadAll(tr)
+		require.NoError(t, err)
+	}
+
+	var vendorData map[string]string
+	require.NoError(t, json.Unmarshal(files["openstack/latest/vendor_data.json"], &vendorData))
+	assert.Equal(t, string(configDrive.GenerateVendorDataContent()), vendorData["cloud-init"])
+
+	ec2 := cloudinit.NewEC2Config()
+	ec2.SetVendorConfig(vendor)
+	require.ErrorIs(t, ec2.WriteISO(new(bytes.Buffer)), cloudinit.ErrVendorDataUnsupported)
+
+	invalid := cloudinit.NewConfig()
+	invalid.SetCloudConfig(&cloudinit.CloudConfig{Output: &cloudinit.OutputConfig{All: &cloudinit.OutputTarget{Stdout: "/var/log/x"}}})
+	require.ErrorIs(t, invalid.WriteISO(new(bytes.Buffer)), cloudinit.ErrInvalidReporting)
+}
+
+func TestVendorDataNotSet(t *testing.T) {
+	output := &cloudinit.OutputConfig{All: &cloudinit.OutputTarget{Stdout: "| tee -a /var/log/cloud-init-output.log"}}
+
+	// Without a vendor cloud-config the sections stay in the user-data.
+	for _, newFunc := range []func() *cloudinit.Config{
+		cloudinit.NewConfig, cloudinit.NewConfigDriveConfig, cloudinit.NewEC2Config, cloudinit.NewGCEConfig,
+	} {
+		c := newFunc()
+		c.SetCloudConfig(&cloudinit.CloudConfig{Output: output})
+
+		var cc cloudinit.CloudConfig
+		require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+		assert.Equal(t, output, cc.Output)
+		assert.Nil(t, c.GenerateVendorDataContent())
+		require.NoError(t, c.WriteISO(new(bytes.Buffer)))
+	}
+}
@@ -0,0 +1,548 @@
+package cloudinit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownSeed is returned by OpenSeed when the volume label does not belong to a known data source.
+var ErrUnknownSeed = errors.New("unknown cloud-init seed volume label")
+
+// Seed holds the files extracted from a cloud-init seed image.
+type Seed struct {
+	// DataSource is the data source detected from the volume label.
+	DataSource DataSourceType
+	// Label is the volume label of the image.
+	Label string
+	// MetaData is the content of the metadata file.
+	MetaData []byte
+	// UserData is the content of the user-data file.
+	UserData []byte
+	// VendorData is the content of the vendor-data file, nil if the image has none.
+	VendorData []byte
+	// NetworkConfig is the content of the network configuration file, nil if the image has none.
+	NetworkConfig []byte
+}
+
+// seedLayout describes where a data source keeps its files on the seed image.
+type seedLayout struct {
+	dataSource    DataSourceType
+	metaData      string
+	userData      string
+	vendorData    string
+	networkConfig string
+}
+
+//nolint:gochecknoglobals // Lookup table of the supported seed layouts.
+var seedLayouts = map[string]seedLayout{
+	VolumeName: {
+		dataSource:    DataSourceNoCloud,
+		metaData:      "meta-data",
+		userData:      "user-data",
+		vendorData:    "vendor-data",
+		networkConfig: "network-config",
+	},
+	ConfigDriveVolumeName: {
+		dataSource:    DataSourceConfigDrive,
+		metaData:      "openstack/latest/meta_data.json",
+		userData:      "openstack/latest/user_data",
+		vendorData:    "openstack/latest/vendor_data.json",
+		networkConfig: "openstack/latest/network_data.json",
+	},
+	EC2VolumeName: {
+		dataSource:    DataSourceEC2,
+		metaData:      "ec2/latest/meta-data.json",
+		userData:      "ec2/latest/user-data",
+		networkConfig: "ec2/latest/network-data.json",
+	},
+	GCEVolumeName: {
+		dataSource:    DataSourceGCE,
+		metaData:      "computeMetadata/v1/instance/attributes.json",
+		userData:      "user-data",
+		networkConfig: "network-config",
+	},
+}
+
+// seedFile is a file of the seed, with its path relative to the volume root.
+type seedFile struct {
+	path string
+	data []byte
+}
+
+// seedFiles returns the volume label and the files of the seed for the
+// configured data source. Every seed format is written from this list.
+func (c *Config) seedFiles() (string, []seedFile, error) {
+	var (
+		label    string
+		meta     interface{}
+		metaData []byte
+		err      error
+	)
+
+	switch c.dataSourceType {
+	case string(DataSourceEC2):
+		label = EC2VolumeName
+		// EC2 expects metadata in JSON format
+		meta, err = c.generateEC2Metadata()
+	case string(DataSourceGCE):
+		label = GCEVolumeName
+		meta, err = c.generateGCEMetadata()
+	case string(DataSourceConfigDrive):
+		label = ConfigDriveVolumeName
+		meta, err = c.generateConfigDriveMetadata()
+	default:
+		label = VolumeName
+		metaData, err = c.generateMetadataContent()
+	}
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if meta != nil {
+		if metaData, err = json.Marshal(meta); err != nil {
+			return "", nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+	}
+
+	userData, err := c.generateConfigContent()
+	if err != nil {
+		return "", nil, err
+	}
+
+	vendorData, err := c.seedVendorData()
+	if err != nil {
+		return "", nil, err
+	}
+
+	layout := seedLayouts[label]
+	files := []seedFile{
+		{path: layout.metaData, data: metaData},
+		{path: layout.userData, data: userData},
+	}
+
+	if vendorData != nil {
+		files = append(files, seedFile{path: layout.vendorData, data: vendorData})
+	}
+
+	if len(c.networkInterfaces) > 0 {
+		networkConfig, err := c.generateNetworkConfig()
+		if err != nil {
+			return "", nil, err
+		}
+
+		files = append(files, seedFile{path: layout.networkConfig, data: networkConfig})
+	}
+
+	return label, files, nil
+}
+
+// generateNetworkConfig renders the network interfaces in the format of the configured data source.
+func (c *Config) generateNetworkConfig() ([]byte, error) {
+	switch c.dataSourceType {
+	case string(DataSourceEC2):
+		return c.generateEC2NetworkConfig(), nil
+	case string(DataSourceGCE):
+		return c.generateGCENetworkConfig(), nil
+	case string(DataSourceConfigDrive):
+		return c.generateConfigDriveNetworkConfig()
+	default:
+		return c.generateNoCloudNetworkConfig()
+	}
+}
+
+// OpenSeed reads a seed ISO9660 or VFAT image, detects its data source by the
+// volume label and extracts the metadata, user-data and network configuration.
+func OpenSeed(r io.ReaderAt) (*Seed, error) {
+	label, vol, err := openSeedVolume(r)
+	if err != nil {
+		return nil, err
+	}
+
+	label = strings.TrimSpace(label)
+
+	layout, ok := seedLayouts[strings.ToLower(label)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSeed, label)
+	}
+
+	s := &Seed{
+		DataSource: layout.dataSource,
+		Label:      label,
+	}
+
+	if s.MetaData, err = vol.readFile(layout.metaData); err != nil {
+		return nil, err
+	}
+
+	if s.UserData, err = vol.readFile(layout.userData); err != nil {
+		return nil, err
+	}
+
+	if layout.vendorData != "" {
+		s.VendorData, err = vol.readFile(layout.vendorData)
+		if err != nil && !isNotExist(err) {
+			return nil, err
+		}
+	}
+
+	s.NetworkConfig, err = vol.readFile(layout.networkConfig)
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// seedVolume is a filesystem image seed files are read from.
+type seedVolume interface {
+	readFile(filePath string) ([]byte, error)
+}
+
+func openSeedVolume(r io.ReaderAt) (string, seedVolume, error) {
+	img, isoErr := iso9660.OpenImage(r)
+	if isoErr != nil {
+		vol, err := openFATVolume(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open seed image, not ISO9660 (%w) nor FAT (%w)", isoErr, err)
+		}
+
+		return vol.label, vol, nil
+	}
+
+	label, err := img.Label()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read volume label: %w", err)
+	}
+
+	root, err := img.RootDir()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read root directory: %w", err)
+	}
+
+	return label, isoVolume{root: root}, nil
+}
+
+type seedFileNotFoundError struct {
+	path string
+}
+
+func (e *seedFileNotFoundError) Error() string {
+	return fmt.Sprintf("seed image has no %s", e.path)
+}
+
+func isNotExist(err error) bool {
+	var nf *seedFileNotFoundError
+	return errors.As(err, &nf)
+}
+
+// isoVolume reads files from an ISO9660 image.
+type isoVolume struct {
+	root *iso9660.File
+}
+
+// readFile looks up a file by path. ISO9660 names are mangled to lower case,
+// so the path components are compared case-insensitively.
+func (v isoVolume) readFile(filePath string) ([]byte, error) {
+	current := v.root
+
+	for _, name := range strings.Split(filePath, "/") {
+		children, err := current.GetChildren()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+
+		var next *iso9660.File
+
+		for _, child := range children {
+			if strings.EqualFold(child.Name(), name) {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			return nil, &seedFileNotFoundError{path: filePath}
+		}
+
+		current = next
+	}
+
+	if current.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", filePath)
+	}
+
+	data, err := io.ReadAll(current.Reader())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	return data, nil
+}
+
+// Config reconstructs the configuration the seed was generated from. Settings
+// that Config has no setter for are kept as its base cloud-config, so the
+// generated content matches the seed.
+func (s *Seed) Config() (*Config, error) {
+	var c *Config
+
+	switch s.DataSource {
+	case DataSourceEC2:
+		c = NewEC2Config()
+		if err := json.Unmarshal(s.MetaData, c.ec2Meta); err != nil {
+			return nil, fmt.Errorf("failed to parse EC2 metadata: %w", err)
+		}
+
+		c.setInstanceIDFromMetadata(c.ec2Meta.InstanceID)
+
+		if c.ec2Meta.LocalHostname != "" {
+			c.fqdn = c.ec2Meta.LocalHostname
+		}
+	case DataSourceGCE:
+		c = NewGCEConfig()
+		if err := json.Unmarshal(s.MetaData, c.gceMetadata); err != nil {
+			return nil, fmt.Errorf("failed to parse GCE metadata: %w", err)
+		}
+
+		c.setInstanceIDFromMetadata(c.gceMetadata.Instance.ID)
+
+		if c.gceMetadata.Instance.Hostname != "" {
+			c.fqdn = c.gceMetadata.Instance.Hostname
+		}
+	case DataSourceConfigDrive:
+		c = NewConfigDriveConfig()
+		if err := json.Unmarshal(s.MetaData, c.configDriveMeta); err != nil {
+			return nil, fmt.Errorf("failed to parse ConfigDrive metadata: %w", err)
+		}
+
+		c.setInstanceIDFromMetadata(c.configDriveMeta.UUID)
+
+		if c.configDriveMeta.Hostname != "" {
+			c.fqdn = c.configDriveMeta.Hostname
+		}
+	case DataSourceNoCloud:
+		c = NewConfig()
+
+		var m Metadata
+		if err := yaml.Unmarshal(s.MetaData, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse meta-data: %w", err)
+		}
+
+		c.setInstanceIDFromMetadata(m.InstanceID)
+
+		if m.LocalHostname != "" {
+			c.fqdn = m.LocalHostname
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSeed, s.DataSource)
+	}
+
+	if err := c.applyUserData(s.UserData); err != nil {
+		return nil, err
+	}
+
+	if len(s.VendorData) > 0 {
+		if err := c.applyVendorData(s.DataSource, s.VendorData); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.NetworkConfig) > 0 {
+		if err := c.applyNetworkConfig(s.DataSource, s.NetworkConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// applyUserData moves the settings Config generates itself out of the
+// user-data, and keeps the rest as the base cloud-config.
+func (c *Config) applyUserData(data []byte) error {
+	cc := new(CloudConfig)
+	if err := yaml.Unmarshal(data, cc); err != nil {
+		return fmt.Errorf("failed to parse user-data: %w", err)
+	}
+
+	c.groups = append(c.groups, cc.Groups...)
+	cc.Groups = nil
+
+	if cc.Users != nil && len(cc.Users) == 0 {
+		c.SetDefaultUser(false)
+	}
+
+	for _, user := range cc.Users {
+		c.users = append(c.users, *user)
+	}
+	cc.Users = nil
+
+	passwords := make([]PasswordChangeUser, 0, len(cc.PasswordChange.Users))
+	for _, entry := range cc.PasswordChange.Users {
+		if entry.Type == PasswordTypeHash {
+			c.passwords = append(c.passwords, entry)
+			continue
+		}
+		passwords = append(passwords, entry)
+	}
+	cc.PasswordChange.Users = passwords
+
+	// Seeds written by older versions have the root password in the list form.
+	list := make([]string, 0, len(cc.PasswordChange.List))
+	for _, entry := range cc.PasswordChange.List {
+		if password, ok := strings.CutPrefix(entry, "root:"); ok && strings.HasPrefix(password, "$") {
+			c.passwords = append(c.passwords, PasswordChangeUser{Name: "root", Password: password, Type: PasswordTypeHash})
+			continue
+		}
+		list = append(list, entry)
+	}
+	cc.PasswordChange.List = list
+
+	c.extractHostname(cc)
+	c.extractTime(cc)
+	c.extractCACerts(cc)
+
+	if err := c.extractSSHHostKeys(cc); err != nil {
+		return err
+	}
+
+	if err := c.extractSSHCA(cc); err != nil {
+		return err
+	}
+
+	if err := c.extractSSHD(cc); err != nil {
+		return err
+	}
+
+	c.extractScripts(cc)
+
+	for _, pm := range knownPackageManagers() {
+		pkg := slices.Index(cc.Packages, Package{Name: pm.GuestAgentPackage})
+		cmd := slices.IndexFunc(cc.RunCommands, func(cmd Command) bool {
+			return cmd.Args == nil && cmd.Shell == pm.EnableServiceCommand(pm.GuestAgentService)
+		})
+
+		if pkg >= 0 && cmd >= 0 {
+			c.enableGuestAgent = true
+			c.SetPackageManager(pm)
+			cc.PackageUpdate = false
+			cc.Packages = slices.Delete(cc.Packages, pkg, pkg+1)
+			cc.RunCommands = slices.Delete(cc.RunCommands, cmd, cmd+1)
+
+			break
+		}
+	}
+
+	c.cloudConfig = cc
+
+	return nil
+}
+
+func (c *Config) applyNetworkConfig(ds DataSourceType, data []byte) error {
+	switch ds {
+	case DataSourceEC2:
+		var nd struct {
+			Interfaces []struct {
+				MACAddress string   `json:"mac"`
+				IPAddress  string   `json:"ip"`
+				Gateway    string   `json:"gateway"`
+				DNS        []string `json:"dns"`
+			} `json:"interfaces"`
+		}
+
+		if err := json.Unmarshal(data, &nd); err != nil {
+			return fmt.Errorf("failed to parse EC2 network data: %w", err)
+		}
+
+		for _, iface := range nd.Interfaces {
+			c.SetStaticInterfaceAddress(iface.MACAddress, iface.IPAddress, iface.Gateway, iface.DNS...)
+		}
+	case DataSourceConfigDrive:
+		return c.applyConfigDriveNetworkConfig(data)
+	case DataSourceGCE:
+		// GCE uses the version 1 format without the top level network key, in JSON.
+		var n Network
+		if err := yaml.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("failed to parse network-config: %w", err)
+		}
+
+		c.applyNetworkV1(n)
+	case DataSourceNoCloud:
+		var nc NetworkConfigFile
+		if err := yaml.Unmarshal(data, &nc); err != nil {
+			return fmt.Errorf("failed to parse network-config: %w", err)
+		}
+
+		c.applyNetworkV1(nc.Network)
+	}
+
+	return nil
+}
+
+func (c *Config) applyNetworkV1(n Network) {
+	for _, nc := range n.Config {
+		if nc.Type != NetworkConfigTypePhysical || nc.MACAddress == "" {
+			continue
+		}
+
+		for _, subnet := range nc.Subnets {
+			if subnet.Type == SubnetTypeStatic {
+				c.SetStaticInterfaceAddress(nc.MACAddress, subnet.Address, subnet.Gateway, subnet.Nameservers...)
+				break
+			}
+		}
+	}
+}
+
+func (c *Config) applyConfigDriveNetworkConfig(data []byte) error {
+	var nd configDriveNetworkData
+	if err := json.Unmarshal(data, &nd); err != nil {
+		return fmt.Errorf("failed to parse ConfigDrive network data: %w", err)
+	}
+
+	macs := make(map[string]string, len(nd.Links))
+	for _, link := range nd.Links {
+		macs[link.ID] = link.MACAddress
+	}
+
+	nameservers := make([]string, 0, len(nd.Services))
+	for _, svc := range nd.Services {
+		if svc.Type == "dns" {
+			nameservers = append(nameservers, svc.Address)
+		}
+	}
+
+	for _, network := range nd.Networks {
+		mac, ok := macs[network.Link]
+		if !ok || network.IPAddress == "" {
+			continue
+		}
+
+		mask := net.ParseIP(network.Netmask)
+		if mask == nil {
+			return fmt.Errorf("invalid netmask %q of network %s", network.Netmask, network.ID)
+		}
+
+		if v4 := mask.To4(); v4 != nil && network.Type != "ipv6" {
+			mask = v4
+		}
+
+		ones, _ := net.IPMask(mask).Size()
+
+		gateway := ""
+		if len(network.Routes) > 0 {
+			gateway = network.Routes[0].Gateway
+		}
+
+		c.SetStaticInterfaceAddress(mac, fmt.Sprintf("%s/%d", network.IPAddress, ones), gateway, nameservers...)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+)
+
+func TestOpenSeedRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name       string
+		newFunc    func() *cloudinit.Config
+		dataSource cloudinit.DataSourceType
+		label      string
+	}{
+		{name: "NoCloud", newFunc: cloudinit.NewConfig, dataSource: cloudinit.DataSourceNoCloud, label: "cidata"},
+		{name: "EC2", newFunc: cloudinit.NewEC2Config, dataSource: cloudinit.DataSourceEC2, label: "ec2-seed"},
+		{name: "GCE", newFunc: cloudinit.NewGCEConfig, dataSource: cloudinit.DataSourceGCE, label: "google-compute-engine"},
+		{name: "ConfigDrive", newFunc: cloudinit.NewConfigDriveConfig, dataSource: cloudinit.DataSourceConfigDrive, label: "config-2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.newFunc()
+			c.SetFQDN("seed.example.com")
+			c.SetRootPassword("$6$salt$hash")
+			c.EnableGuestAgent()
+			c.SetCloudConfig(&cloudinit.CloudConfig{Timezone: "UTC", Packages: []cloudinit.Package{{Name: "curl"}}})
+			c.AddUser(cloudinit.User{Name: "admin", Groups: []string{"sudo"}, Password: "$6$salt$hash"})
+			c.AddGroup("operators", "admin")
+			c.SetDefaultUser(true)
+			require.NoError(t, c.SetHostnameConfig(cloudinit.HostnameConfig{ManageEtcHosts: cloudinit.EtcHostsTemplate}))
+			require.NoError(t, c.AddTrustedCA(testCertificate(t, true, time.Now().AddDate(1, 0, 0))))
+			require.NoError(t, c.AddScript(cloudinit.ScriptPerBoot, "10-motd", "#!/bin/sh\necho booted\n"))
+			_, err := c.GenerateSSHHostKey(cloudinit.SSHKeyEd25519)
+			require.NoError(t, err)
+			_, err = c.AddWireGuardTunnel(wireGuardTunnel())
+			require.NoError(t, err)
+			c.SetStaticInterfaceAddress("00:11:22:33:44:55", "192.168.1.100/24", "192.168.1.1", "8.8.8.8", "8.8.4.4")
+
+			switch tc.dataSource {
+			case cloudinit.DataSourceEC2:
+				require.NoError(t, c.SetEC2Metadata("i-1234567890", "us-east-1a", map[string]string{"env": "test"}))
+			case cloudinit.DataSourceGCE:
+				c.SetGCEMetadata("seed", "us-central1-a", "test-project")
+				c.AddGCELabel("env", "test")
+			case cloudinit.DataSourceConfigDrive:
+				require.NoError(t, c.SetConfigDriveMetadata("2c1f3a80-6f5e-4b35-9bcb-2d3c0d2b3f6e", "nova", nil))
+			case cloudinit.DataSourceNoCloud:
+			}
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, c.WriteISO(buf))
+
+			seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.dataSource, seed.DataSource)
+			assert.Equal(t, tc.label, seed.Label)
+			assert.Equal(t, c.GenerateConfigContent(), seed.UserData)
+			assert.NotEmpty(t, seed.MetaData)
+			assert.NotEmpty(t, seed.NetworkConfig)
+
+			restored, err := seed.Config()
+			require.NoError(t, err)
+
+			assert.Equal(t, string(c.GenerateConfigContent()), string(restored.GenerateConfigContent()))
+
+			rebuilt := new(bytes.Buffer)
+			require.NoError(t, restored.WriteISO(rebuilt))
+
+			reopened, err := cloudinit.OpenSeed(bytes.NewReader(rebuilt.Bytes()))
+			require.NoError(t, err)
+
+			assert.Equal(t, seed.MetaData, reopened.MetaData)
+			assert.Equal(t, seed.UserData, reopened.UserData)
+			assert.Equal(t, seed.NetworkConfig, reopened.NetworkConfig)
+		})
+	}
+}
+
+func TestOpenSeedWithoutNetworkConfig(t *testing.T) {
+	c := cloudinit.NewConfig()
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, c.WriteISO(buf))
+
+	seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	assert.Nil(t, seed.NetworkConfig)
+	assert.Equal(t, c.GenerateMetadataContent(), seed.MetaData)
+}
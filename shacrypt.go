@@ -0,0 +1,189 @@
+package cloudinit
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+)
+
+// cryptAlphabet is the base64 alphabet of the crypt(3) hash formats.
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptSaltLength    = 16
+)
+
+// sha512CryptOrder is the byte order the SHA-512 crypt digest is encoded in.
+//
+//nolint:gochecknoglobals // Encoding table.
+var sha512CryptOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4}, {47, 5, 26}, {6, 27, 48},
+	{28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13},
+	{56, 14, 35}, {15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41},
+}
+
+// sha256CryptOrder is the byte order the SHA-256 crypt digest is encoded in.
+//
+//nolint:gochecknoglobals // Encoding table.
+var sha256CryptOrder = [][3]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+}
+
+// shaCrypt implements the SHA-crypt scheme of glibc.
+// For more information see: https://www.akkadia.org/drepper/SHA-crypt.txt
+func shaCrypt(newHash func() hash.Hash, magic string, password string, rounds int, random io.Reader) (string, error) {
+	customRounds := rounds != 0
+	if !customRounds {
+		rounds = shaCryptDefaultRounds
+	}
+
+	if rounds < shaCryptMinRounds || rounds > shaCryptMaxRounds {
+		return "", fmt.Errorf("invalid number of rounds %d", rounds)
+	}
+
+	salt, err := cryptSalt(shaCryptSaltLength, random)
+	if err != nil {
+		return "", err
+	}
+
+	pw := []byte(password)
+	size := newHash().Size()
+
+	alt := newHash()
+	alt.Write(pw)
+	alt.Write(salt)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	a := newHash()
+	a.Write(pw)
+	a.Write(salt)
+
+	for n := len(pw); n > 0; n -= size {
+		a.Write(altSum[:min(n, size)])
+	}
+
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(altSum)
+		} else {
+			a.Write(pw)
+		}
+	}
+
+	sum := a.Sum(nil)
+
+	dp := newHash()
+	for range pw {
+		dp.Write(pw)
+	}
+
+	p := repeatDigest(dp.Sum(nil), len(pw))
+
+	ds := newHash()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(salt)
+	}
+
+	s := repeatDigest(ds.Sum(nil), len(salt))
+
+	for i := range rounds {
+		c := newHash()
+
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+
+		if i%3 != 0 {
+			c.Write(s)
+		}
+
+		if i%7 != 0 {
+			c.Write(p)
+		}
+
+		if i&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+
+		sum = c.Sum(sum[:0])
+	}
+
+	out := []byte(magic)
+	if customRounds {
+		out = append(out, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+
+	out = append(out, salt...)
+	out = append(out, '$')
+
+	if size == sha512.Size {
+		for _, g := range sha512CryptOrder {
+			out = cryptEncode24(out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+
+		out = cryptEncode24(out, 0, 0, sum[63], 2)
+	} else {
+		for _, g := range sha256CryptOrder {
+			out = cryptEncode24(out, sum[g[0]], sum[g[1]], sum[g[2]], 4)
+		}
+
+		out = cryptEncode24(out, 0, sum[31], sum[30], 3)
+	}
+
+	return string(out), nil
+}
+
+func sha512Crypt(password string, rounds int, random io.Reader) (string, error) {
+	return shaCrypt(sha512.New, "$6$", password, rounds, random)
+}
+
+func sha256Crypt(password string, rounds int, random io.Reader) (string, error) {
+	return shaCrypt(sha256.New, "$5$", password, rounds, random)
+}
+
+// repeatDigest repeats the digest up to n bytes.
+func repeatDigest(digest []byte, n int) []byte {
+	out := make([]byte, n)
+	for i := 0; i < n; i += len(digest) {
+		copy(out[i:], digest)
+	}
+
+	return out
+}
+
+// cryptEncode24 appends n characters encoding the 24 bit value b2:b1:b0, least significant bits first.
+func cryptEncode24(dst []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		dst = append(dst, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+
+	return dst
+}
+
+// cryptSalt returns n random characters of the crypt alphabet.
+func cryptSalt(n int, random io.Reader) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	for i, b := range salt {
+		salt[i] = cryptAlphabet[b&0x3f]
+	}
+
+	return salt, nil
+}
@@ -0,0 +1,247 @@
+package cloudinit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHKeyType is the algorithm of an SSH host key.
+type SSHKeyType string
+
+const (
+	SSHKeyEd25519 SSHKeyType = "ed25519"
+	SSHKeyECDSA   SSHKeyType = "ecdsa"
+	SSHKeyRSA     SSHKeyType = "rsa"
+)
+
+// sshRSAKeyBits is the size of generated RSA host keys, the ssh-keygen default.
+const sshRSAKeyBits = 3072
+
+// ErrUnsupportedKeyType is returned for SSH keys other than Ed25519, ECDSA and RSA.
+var ErrUnsupportedKeyType = errors.New("unsupported SSH key type")
+
+// SSHHostKey is a host key pre-seeded with the ssh_keys module.
+type SSHHostKey struct {
+	// Type is the algorithm of the key.
+	Type SSHKeyType
+	// PublicKey is the public key in authorized_keys format.
+	PublicKey string
+	// Fingerprint is the SHA256 fingerprint, as printed by ssh-keygen -l.
+	Fingerprint string
+	// PrivateKey is the private key in OpenSSH PEM format.
+	PrivateKey []byte
+	// Certificate is the host certificate in authorized_keys format, set by SignSSHHostKeys.
+	Certificate string
+
+	publicKey ssh.PublicKey
+}
+
+// GenerateSSHHostKey generates a host key of the given type and adds it
+// with AddSSHHostKey. Ed25519 keys are reproducible with SetRandom, ECDSA
+// and RSA keys are not, as Go does not guarantee deterministic generation.
+func (c *Config) GenerateSSHHostKey(keyType SSHKeyType) (*SSHHostKey, error) {
+	var (
+		key crypto.PrivateKey
+		err error
+	)
+
+	switch keyType {
+	case SSHKeyEd25519:
+		_, key, err = ed25519.GenerateKey(c.random)
+	case SSHKeyECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), c.random)
+	case SSHKeyRSA:
+		key, err = rsa.GenerateKey(c.random, sshRSAKeyBits)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedKeyType, keyType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s host key: %w", keyType, err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s host key: %w", keyType, err)
+	}
+
+	if err := setOpenSSHCheck(block, c.random); err != nil {
+		return nil, err
+	}
+
+	return c.AddSSHHostKey(pem.EncodeToMemory(block))
+}
+
+// AddSSHHostKey adds an existing private host key in PEM format, replacing
+// the key of the same type. The keys are written with the ssh_keys module,
+// and ssh_deletekeys is disabled so cloud-init keeps them.
+func (c *Config) AddSSHHostKey(privateKey []byte) (*SSHHostKey, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %w", err)
+	}
+
+	pub := signer.PublicKey()
+
+	var keyType SSHKeyType
+
+	switch pub.Type() {
+	case ssh.KeyAlgoED25519:
+		keyType = SSHKeyEd25519
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		keyType = SSHKeyECDSA
+	case ssh.KeyAlgoRSA:
+		keyType = SSHKeyRSA
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedKeyType, pub.Type())
+	}
+
+	key := &SSHHostKey{
+		Type:        keyType,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))),
+		Fingerprint: ssh.FingerprintSHA256(pub),
+		PrivateKey:  privateKey,
+		publicKey:   pub,
+	}
+
+	for i, k := range c.sshHostKeys {
+		if k.Type == keyType {
+			c.sshHostKeys[i] = key
+			return key, nil
+		}
+	}
+
+	c.sshHostKeys = append(c.sshHostKeys, key)
+
+	return key, nil
+}
+
+// SSHHostKeys returns the pre-seeded host keys.
+func (c *Config) SSHHostKeys() []*SSHHostKey {
+	return c.sshHostKeys
+}
+
+// KnownHosts returns the known_hosts lines of the pre-seeded host keys for the FQDN.
+func (c *Config) KnownHosts() []string {
+	lines := make([]string, 0, len(c.sshHostKeys))
+	for _, key := range c.sshHostKeys {
+		lines = append(lines, key.KnownHostsLine(c.fqdn))
+	}
+
+	return lines
+}
+
+// KnownHostsLine returns the known_hosts line of the key for the given hosts.
+func (k *SSHHostKey) KnownHostsLine(hosts ...string) string {
+	return knownhosts.Line(hosts, k.publicKey)
+}
+
+// applySSHHostKeys writes the host keys into the ssh_keys section.
+func (c *Config) applySSHHostKeys(cc *CloudConfig) {
+	if len(c.sshHostKeys) == 0 {
+		return
+	}
+
+	if cc.SSH.Keys == nil {
+		cc.SSH.Keys = new(SSHKeys)
+	}
+
+	for _, key := range c.sshHostKeys {
+		private := string(key.PrivateKey)
+
+		switch key.Type {
+		case SSHKeyEd25519:
+			cc.SSH.Keys.Ed25519Private, cc.SSH.Keys.Ed25519Public = private, key.PublicKey
+		case SSHKeyECDSA:
+			cc.SSH.Keys.ECDSAPrivate, cc.SSH.Keys.ECDSAPublic = private, key.PublicKey
+		case SSHKeyRSA:
+			cc.SSH.Keys.RSAPrivate, cc.SSH.Keys.RSAPublic = private, key.PublicKey
+		}
+	}
+
+	deleteKeys := false
+	cc.SSH.DeleteKeys = &deleteKeys
+}
+
+// extractSSHHostKeys moves the host keys of the ssh_keys section back into
+// Config, the inverse of applySSHHostKeys.
+func (c *Config) extractSSHHostKeys(cc *CloudConfig) error {
+	if cc.SSH.Keys == nil {
+		return nil
+	}
+
+	for _, private := range []*string{&cc.SSH.Keys.RSAPrivate, &cc.SSH.Keys.ECDSAPrivate, &cc.SSH.Keys.Ed25519Private} {
+		if *private == "" {
+			continue
+		}
+
+		if _, err := c.AddSSHHostKey([]byte(*private)); err != nil {
+			return err
+		}
+
+		*private = ""
+	}
+
+	cc.SSH.Keys.RSAPublic, cc.SSH.Keys.ECDSAPublic, cc.SSH.Keys.Ed25519Public = "", "", ""
+	if *cc.SSH.Keys == (SSHKeys{}) {
+		cc.SSH.Keys = nil
+	}
+
+	if len(c.sshHostKeys) > 0 && cc.SSH.DeleteKeys != nil && !*cc.SSH.DeleteKeys {
+		cc.SSH.DeleteKeys = nil
+	}
+
+	return nil
+}
+
+// setOpenSSHCheck replaces the check value of an unencrypted OpenSSH private
+// key, which ssh.MarshalPrivateKey always reads from crypto/rand.
+func setOpenSSHCheck(block *pem.Block, random io.Reader) error {
+	const magic = "openssh-key-v1\x00"
+
+	data := block.Bytes
+	if !strings.HasPrefix(string(data), magic) {
+		return errors.New("not an OpenSSH private key")
+	}
+
+	// Skip the cipher name, KDF name, KDF options, key count and public key.
+	off := len(magic)
+	for i := 0; i < 4; i++ {
+		if i == 3 {
+			off += 4
+		}
+
+		if off+4 > len(data) {
+			return errors.New("truncated OpenSSH private key")
+		}
+
+		off += 4 + int(binary.BigEndian.Uint32(data[off:]))
+	}
+
+	// The private section starts with its length and the check value repeated twice.
+	if off+12 > len(data) {
+		return errors.New("truncated OpenSSH private key")
+	}
+
+	check := make([]byte, 4)
+	if _, err := io.ReadFull(random, check); err != nil {
+		return fmt.Errorf("failed to read check value: %w", err)
+	}
+
+	copy(data[off+4:], check)
+	copy(data[off+8:], check)
+
+	return nil
+}
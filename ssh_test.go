@@ -0,0 +1,71 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGenerateSSHHostKey(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetFQDN("vm.example.com")
+
+	for _, keyType := range []cloudinit.SSHKeyType{cloudinit.SSHKeyEd25519, cloudinit.SSHKeyECDSA, cloudinit.SSHKeyRSA} {
+		key, err := c.GenerateSSHHostKey(keyType)
+		require.NoError(t, err)
+
+		signer, err := ssh.ParsePrivateKey(key.PrivateKey)
+		require.NoError(t, err)
+
+		assert.Equal(t, keyType, key.Type)
+		assert.Equal(t, string(ssh.MarshalAuthorizedKey(signer.PublicKey())), key.PublicKey+"\n")
+		assert.Equal(t, ssh.FingerprintSHA256(signer.PublicKey()), key.Fingerprint)
+	}
+
+	_, err := c.GenerateSSHHostKey("dsa")
+	require.ErrorIs(t, err, cloudinit.ErrUnsupportedKeyType)
+
+	hosts := c.KnownHosts()
+	require.Len(t, hosts, 3)
+
+	_, names, pub, _, _, err := ssh.ParseKnownHosts([]byte(hosts[0]))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vm.example.com"}, names)
+	assert.Equal(t, c.SSHHostKeys()[0].Fingerprint, ssh.FingerprintSHA256(pub))
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	require.NotNil(t, cc.SSH.Keys)
+	require.NotNil(t, cc.SSH.DeleteKeys)
+	assert.False(t, *cc.SSH.DeleteKeys)
+	assert.Equal(t, string(c.SSHHostKeys()[0].PrivateKey), cc.SSH.Keys.Ed25519Private)
+	assert.Equal(t, c.SSHHostKeys()[2].PublicKey, cc.SSH.Keys.RSAPublic)
+}
+
+func TestSSHHostKeyReproducible(t *testing.T) {
+	generate := func() *cloudinit.SSHHostKey {
+		c := cloudinit.NewConfig()
+		c.SetRandom(bytes.NewReader(bytes.Repeat([]byte{0x42}, 64)))
+
+		key, err := c.GenerateSSHHostKey(cloudinit.SSHKeyEd25519)
+		require.NoError(t, err)
+
+		return key
+	}
+
+	first := generate()
+	assert.Equal(t, first.PrivateKey, generate().PrivateKey)
+
+	c := cloudinit.NewConfig()
+	added, err := c.AddSSHHostKey(first.PrivateKey)
+	require.NoError(t, err)
+	assert.Equal(t, first.Fingerprint, added.Fingerprint)
+
+	_, err = c.AddSSHHostKey([]byte("not a key"))
+	require.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshTrustedUserCAKeysPath is the file of the trusted user CA keys.
+const sshTrustedUserCAKeysPath = "/etc/ssh/trusted_user_ca_keys"
+
+// ErrNoSSHHostKeys is returned when signing without pre-seeded host keys.
+var ErrNoSSHHostKeys = errors.New("no SSH host keys to sign")
+
+// SSHCertificateOptions are the options of the signed host certificates.
+type SSHCertificateOptions struct {
+	// Serial is the serial number of the certificates.
+	Serial uint64
+	// KeyID is the key identifier, the FQDN when empty.
+	KeyID string
+	// Principals are the host names the certificates are valid for, the
+	// FQDN and the hostname when empty.
+	Principals []string
+	// ValidAfter is the start of the validity, the current time when zero.
+	ValidAfter time.Time
+	// ValidBefore is the end of the validity, forever when zero.
+	ValidBefore time.Time
+}
+
+// SignSSHHostKeys signs the pre-seeded host keys with the CA. The
+// certificates are written with write_files, and configured in the drop-in
+// of SetSSHDConfig. Host keys added later are not signed.
+func (c *Config) SignSSHHostKeys(ca ssh.Signer, opts SSHCertificateOptions) error {
+	if len(c.sshHostKeys) == 0 {
+		return ErrNoSSHHostKeys
+	}
+
+	if opts.KeyID == "" {
+		opts.KeyID = c.fqdn
+	}
+
+	if len(opts.Principals) == 0 {
+		opts.Principals = []string{c.fqdn}
+		if hostname := c.Hostname(); hostname != c.fqdn {
+			opts.Principals = append(opts.Principals, hostname)
+		}
+	}
+
+	validAfter := uint64(c.now().Unix())
+	if !opts.ValidAfter.IsZero() {
+		validAfter = uint64(opts.ValidAfter.Unix())
+	}
+
+	validBefore := uint64(ssh.CertTimeInfinity)
+	if !opts.ValidBefore.IsZero() {
+		validBefore = uint64(opts.ValidBefore.Unix())
+	}
+
+	for _, key := range c.sshHostKeys {
+		cert := &ssh.Certificate{
+			Key:             key.publicKey,
+			Serial:          opts.Serial,
+			CertType:        ssh.HostCert,
+			KeyId:           opts.KeyID,
+			ValidPrincipals: opts.Principals,
+			ValidAfter:      validAfter,
+			ValidBefore:     validBefore,
+		}
+
+		if err := cert.SignCert(c.random, ca); err != nil {
+			return fmt.Errorf("failed to sign %s host key: %w", key.Type, err)
+		}
+
+		key.Certificate = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+	}
+
+	return nil
+}
+
+// AddTrustedUserCAKey adds a CA whose signed user keys can log in as any
+// user listed in the certificate principals, without AuthorizedKeys.
+func (c *Config) AddTrustedUserCAKey(key ssh.PublicKey) {
+	c.trustedUserCAKeys = append(c.trustedUserCAKeys, key)
+}
+
+// sshHostCertificatePath returns the path of the certificate of the host key type.
+func sshHostCertificatePath(keyType SSHKeyType) string {
+	return "/etc/ssh/ssh_host_" + string(keyType) + "_key-cert.pub"
+}
+
+// applySSHCA writes the host certificates and the trusted user CA keys.
+func (c *Config) applySSHCA(cc *CloudConfig) {
+	for _, key := range c.sshHostKeys {
+		if key.Certificate == "" {
+			continue
+		}
+
+		cc.WriteFiles = append(cc.WriteFiles, WriteFile{
+			Path:        sshHostCertificatePath(key.Type),
+			Content:     key.Certificate + "\n",
+			Permissions: "0644",
+		})
+	}
+
+	if len(c.trustedUserCAKeys) > 0 {
+		var keys strings.Builder
+		for _, key := range c.trustedUserCAKeys {
+			keys.Write(ssh.MarshalAuthorizedKey(key))
+		}
+
+		cc.WriteFiles = append(cc.WriteFiles,
+			WriteFile{Path: sshTrustedUserCAKeysPath, Content: keys.String(), Permissions: "0644"})
+	}
+}
+
+// extractSSHCA moves the files written by applySSHCA back into Config. It
+// must run after extractSSHHostKeys.
+func (c *Config) extractSSHCA(cc *CloudConfig) error {
+	files := make([]WriteFile, 0, len(cc.WriteFiles))
+
+	for _, file := range cc.WriteFiles {
+		switch {
+		case file.Path == sshTrustedUserCAKeysPath:
+			rest := []byte(file.Content)
+			for len(strings.TrimSpace(string(rest))) > 0 {
+				key, _, _, r, err := ssh.ParseAuthorizedKey(rest)
+				if err != nil {
+					return fmt.Errorf("failed to parse trusted user CA keys: %w", err)
+				}
+
+				c.AddTrustedUserCAKey(key)
+				rest = r
+			}
+
+			continue
+		default:
+			i := slices.IndexFunc(c.sshHostKeys, func(k *SSHHostKey) bool {
+				return sshHostCertificatePath(k.Type) == file.Path
+			})
+			if i >= 0 {
+				c.sshHostKeys[i].Certificate = strings.TrimSpace(file.Content)
+				continue
+			}
+		}
+
+		files = append(files, file)
+	}
+
+	cc.WriteFiles = files
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSignSSHHostKeys(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+
+	ca, err := ssh.NewSignerFromKey(caKey)
+	require.NoError(t, err)
+
+	c := cloudinit.NewConfig()
+	c.SetFQDN("vm.example.com")
+	c.SetSourceDate(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	require.ErrorIs(t, c.SignSSHHostKeys(ca, cloudinit.SSHCertificateOptions{}), cloudinit.ErrNoSSHHostKeys)
+
+	key, err := c.GenerateSSHHostKey(cloudinit.SSHKeyEd25519)
+	require.NoError(t, err)
+
+	require.NoError(t, c.SignSSHHostKeys(ca, cloudinit.SSHCertificateOptions{Serial: 7}))
+	c.AddTrustedUserCAKey(ca.PublicKey())
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.Certificate))
+	require.NoError(t, err)
+
+	cert, ok := pub.(*ssh.Certificate)
+	require.True(t, ok)
+	assert.Equal(t, uint32(ssh.HostCert), cert.CertType)
+	assert.Equal(t, uint64(7), cert.Serial)
+	assert.Equal(t, []string{"vm.example.com", "vm"}, cert.ValidPrincipals)
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+		Clock: func() time.Time { return time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	require.NoError(t, checker.CheckCert("vm.example.com", cert))
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+
+	files := make(map[string]string)
+	for _, f := range cc.WriteFiles {
+		files[f.Path] = f.Content
+	}
+
+	assert.Equal(t, key.Certificate+"\n", files["/etc/ssh/ssh_host_ed25519_key-cert.pub"])
+	assert.Equal(t, string(ssh.MarshalAuthorizedKey(ca.PublicKey())), files["/etc/ssh/trusted_user_ca_keys"])
+	assert.Equal(t, "HostCertificate /etc/ssh/ssh_host_ed25519_key-cert.pub\n"+
+		"TrustedUserCAKeys /etc/ssh/trusted_user_ca_keys\n", files["/etc/ssh/sshd_config.d/40-cloud-init.conf"])
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, c.WriteISO(buf))
+
+	seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	restored, err := seed.Config()
+	require.NoError(t, err)
+	require.Len(t, restored.SSHHostKeys(), 1)
+	assert.Equal(t, key.Certificate, restored.SSHHostKeys()[0].Certificate)
+	assert.Equal(t, string(c.GenerateConfigContent()), string(restored.GenerateConfigContent()))
+}
@@ -0,0 +1,297 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshdDropInPath is the sshd configuration drop-in written by Config. sshd
+// uses the first value of each keyword, so it precedes the 50-cloud-init.conf
+// drop-in cloud-init writes for ssh_pwauth.
+const sshdDropInPath = "/etc/ssh/sshd_config.d/40-cloud-init.conf"
+
+// ErrInvalidSSHConfig is returned for invalid SSH and sshd settings.
+var ErrInvalidSSHConfig = errors.New("invalid SSH configuration")
+
+// SSHDConfig holds the sshd settings of the drop-in Config writes with write_files.
+// For more information see: https://man.openbsd.org/sshd_config
+type SSHDConfig struct {
+	// Port is the port sshd listens on, 22 when zero.
+	Port int
+	// ListenAddress are the addresses sshd listens on.
+	ListenAddress []string
+	// PermitRootLogin is "yes", "no", "prohibit-password" or "forced-commands-only".
+	PermitRootLogin string
+	// PasswordAuthentication enables or disables password logins.
+	PasswordAuthentication *bool
+	// AllowUsers are the users allowed to log in, all when empty.
+	AllowUsers []string
+	// AllowGroups are the groups whose members are allowed to log in, all when empty.
+	AllowGroups []string
+	// KexAlgorithms are the allowed key exchange algorithms.
+	KexAlgorithms []string
+	// Ciphers are the allowed ciphers.
+	Ciphers []string
+	// MACs are the allowed message authentication codes.
+	MACs []string
+	// HostCertificates are the paths of the host certificates.
+	HostCertificates []string
+	// TrustedUserCAKeys is the path of the trusted user CA keys.
+	TrustedUserCAKeys string
+}
+
+// Validate checks the settings, so they can not break or extend the sshd configuration.
+func (s SSHDConfig) Validate() error {
+	if s.Port < 0 || s.Port > 65535 {
+		return fmt.Errorf("%w: port %d out of range", ErrInvalidSSHConfig, s.Port)
+	}
+
+	switch s.PermitRootLogin {
+	case "", "yes", "no", "prohibit-password", "without-password", "forced-commands-only":
+	default:
+		return fmt.Errorf("%w: PermitRootLogin %q", ErrInvalidSSHConfig, s.PermitRootLogin)
+	}
+
+	for _, values := range [][]string{
+		s.ListenAddress, s.AllowUsers, s.AllowGroups, s.KexAlgorithms, s.Ciphers, s.MACs,
+		s.HostCertificates, {s.TrustedUserCAKeys},
+	} {
+		for _, v := range values {
+			if strings.ContainsAny(v, " \t\r\n#,") {
+				return fmt.Errorf("%w: invalid value %q", ErrInvalidSSHConfig, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// String renders the settings in sshd_config format.
+func (s SSHDConfig) String() string {
+	var b strings.Builder
+
+	if s.Port != 0 {
+		fmt.Fprintf(&b, "Port %d\n", s.Port)
+	}
+
+	for _, addr := range s.ListenAddress {
+		fmt.Fprintf(&b, "ListenAddress %s\n", addr)
+	}
+
+	if s.PermitRootLogin != "" {
+		fmt.Fprintf(&b, "PermitRootLogin %s\n", s.PermitRootLogin)
+	}
+
+	if s.PasswordAuthentication != nil {
+		fmt.Fprintf(&b, "PasswordAuthentication %s\n", sshdBool(*s.PasswordAuthentication))
+	}
+
+	for _, kv := range []struct {
+		keyword string
+		values  []string
+		sep     string
+	}{
+		{"AllowUsers", s.AllowUsers, " "},
+		{"AllowGroups", s.AllowGroups, " "},
+		{"KexAlgorithms", s.KexAlgorithms, ","},
+		{"Ciphers", s.Ciphers, ","},
+		{"MACs", s.MACs, ","},
+	} {
+		if len(kv.values) > 0 {
+			fmt.Fprintf(&b, "%s %s\n", kv.keyword, strings.Join(kv.values, kv.sep))
+		}
+	}
+
+	for _, path := range s.HostCertificates {
+		fmt.Fprintf(&b, "HostCertificate %s\n", path)
+	}
+
+	if s.TrustedUserCAKeys != "" {
+		fmt.Fprintf(&b, "TrustedUserCAKeys %s\n", s.TrustedUserCAKeys)
+	}
+
+	return b.String()
+}
+
+// WriteFile returns the drop-in file of the settings.
+func (s SSHDConfig) WriteFile() WriteFile {
+	return WriteFile{Path: sshdDropInPath, Content: s.String(), Permissions: "0600"}
+}
+
+func sshdBool(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// parseSSHDConfig parses a drop-in rendered by SSHDConfig.String.
+func parseSSHDConfig(content string) (SSHDConfig, error) {
+	var s SSHDConfig
+
+	for _, line := range strings.Split(content, "\n") {
+		keyword, value, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "Port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return s, fmt.Errorf("%w: port %q", ErrInvalidSSHConfig, value)
+			}
+
+			s.Port = port
+		case "ListenAddress":
+			s.ListenAddress = append(s.ListenAddress, value)
+		case "PermitRootLogin":
+			s.PermitRootLogin = value
+		case "PasswordAuthentication":
+			enabled := value == "yes"
+			s.PasswordAuthentication = &enabled
+		case "AllowUsers":
+			s.AllowUsers = strings.Fields(value)
+		case "AllowGroups":
+			s.AllowGroups = strings.Fields(value)
+		case "KexAlgorithms":
+			s.KexAlgorithms = strings.Split(value, ",")
+		case "Ciphers":
+			s.Ciphers = strings.Split(value, ",")
+		case "MACs":
+			s.MACs = strings.Split(value, ",")
+		case "HostCertificate":
+			s.HostCertificates = append(s.HostCertificates, value)
+		case "TrustedUserCAKeys":
+			s.TrustedUserCAKeys = value
+		default:
+			return s, fmt.Errorf("%w: unknown keyword %q", ErrInvalidSSHConfig, keyword)
+		}
+	}
+
+	return s, nil
+}
+
+// SetSSHDConfig sets the sshd settings written as a drop-in with write_files.
+// The host certificates and trusted user CA keys are added by Config. The
+// drop-in requires an sshd_config that includes sshd_config.d, i.e. OpenSSH
+// 8.2 or later as shipped by Debian 11, Ubuntu 20.04 and RHEL 9. Older
+// distros, e.g. Debian 10 and RHEL 8, silently ignore it.
+func (c *Config) SetSSHDConfig(sshd SSHDConfig) error {
+	if err := sshd.Validate(); err != nil {
+		return err
+	}
+
+	c.sshdConfig = sshd
+
+	return nil
+}
+
+// AddAuthorizedKey adds a public key to the ssh_authorized_keys of the default user.
+func (c *Config) AddAuthorizedKey(key string) error {
+	if err := ValidateAuthorizedKey(key); err != nil {
+		return err
+	}
+
+	c.authorizedKeys = append(c.authorizedKeys, key)
+
+	return nil
+}
+
+// ValidateAuthorizedKey checks that the key is a single public key in authorized_keys format.
+func ValidateAuthorizedKey(key string) error {
+	_, _, _, rest, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return fmt.Errorf("%w: invalid public key %q: %w", ErrInvalidSSHConfig, key, err)
+	}
+
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return fmt.Errorf("%w: more than one public key in %q", ErrInvalidSSHConfig, key)
+	}
+
+	return nil
+}
+
+// Validate checks the public keys and the host key types.
+func (s SSHConfig) Validate() error {
+	for _, key := range s.AuthorizedKeys {
+		if err := ValidateAuthorizedKey(key); err != nil {
+			return err
+		}
+	}
+
+	for _, keyType := range s.GenKeyTypes {
+		switch keyType {
+		case string(SSHKeyEd25519), string(SSHKeyECDSA), string(SSHKeyRSA), "dsa":
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedKeyType, keyType)
+		}
+	}
+
+	return nil
+}
+
+// applySSHD writes the authorized keys and the sshd drop-in. It must run
+// after applySSHCA, whose files the drop-in refers to.
+func (c *Config) applySSHD(cc *CloudConfig) {
+	cc.SSH.AuthorizedKeys = append(cc.SSH.AuthorizedKeys, c.authorizedKeys...)
+
+	sshd := c.sshdConfig
+	sshd.HostCertificates = slices.Clone(sshd.HostCertificates)
+
+	for _, key := range c.sshHostKeys {
+		if key.Certificate != "" {
+			sshd.HostCertificates = append(sshd.HostCertificates, sshHostCertificatePath(key.Type))
+		}
+	}
+
+	if len(c.trustedUserCAKeys) > 0 {
+		sshd.TrustedUserCAKeys = sshTrustedUserCAKeysPath
+	}
+
+	if sshd.String() != "" {
+		cc.WriteFiles = append(cc.WriteFiles, sshd.WriteFile())
+	}
+}
+
+// extractSSHD moves the authorized keys and the sshd drop-in back into
+// Config, the inverse of applySSHD. It must run after extractSSHCA.
+func (c *Config) extractSSHD(cc *CloudConfig) error {
+	c.authorizedKeys = append(c.authorizedKeys, cc.SSH.AuthorizedKeys...)
+	cc.SSH.AuthorizedKeys = nil
+
+	i := slices.IndexFunc(cc.WriteFiles, func(f WriteFile) bool { return f.Path == sshdDropInPath })
+	if i < 0 {
+		return nil
+	}
+
+	sshd, err := parseSSHDConfig(cc.WriteFiles[i].Content)
+	if err != nil {
+		return err
+	}
+
+	sshd.HostCertificates = slices.DeleteFunc(sshd.HostCertificates, func(path string) bool {
+		return slices.ContainsFunc(c.sshHostKeys, func(k *SSHHostKey) bool {
+			return k.Certificate != "" && sshHostCertificatePath(k.Type) == path
+		})
+	})
+
+	if len(sshd.HostCertificates) == 0 {
+		sshd.HostCertificates = nil
+	}
+
+	if len(c.trustedUserCAKeys) > 0 && sshd.TrustedUserCAKeys == sshTrustedUserCAKeysPath {
+		sshd.TrustedUserCAKeys = ""
+	}
+
+	c.sshdConfig = sshd
+	cc.WriteFiles = slices.Delete(cc.WriteFiles, i, i+1)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+func testAuthorizedKey(t *testing.T) string {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{2}, 32)))
+	require.NoError(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	return string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(key))) + " admin@example.com"
+}
+
+func TestSSHDConfig(t *testing.T) {
+	disabled := false
+	sshd := cloudinit.SSHDConfig{
+		Port:                   2222,
+		PermitRootLogin:        "prohibit-password",
+		PasswordAuthentication: &disabled,
+		AllowUsers:             []string{"admin", "deploy"},
+		KexAlgorithms:          []string{"sntrup761x25519-sha512@openssh.com", "curve25519-sha256"},
+	}
+	require.NoError(t, sshd.Validate())
+
+	assert.Equal(t, "Port 2222\n"+
+		"PermitRootLogin prohibit-password\n"+
+		"PasswordAuthentication no\n"+
+		"AllowUsers admin deploy\n"+
+		"KexAlgorithms sntrup761x25519-sha512@openssh.com,curve25519-sha256\n", sshd.String())
+
+	for _, invalid := range []cloudinit.SSHDConfig{
+		{Port: 70000},
+		{PermitRootLogin: "maybe"},
+		{AllowUsers: []string{"admin\nPermitRootLogin yes"}},
+	} {
+		require.ErrorIs(t, invalid.Validate(), cloudinit.ErrInvalidSSHConfig)
+	}
+
+	c := cloudinit.NewConfig()
+	require.Error(t, c.SetSSHDConfig(cloudinit.SSHDConfig{Port: -1}))
+	require.NoError(t, c.SetSSHDConfig(sshd))
+
+	ca, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testAuthorizedKey(t)))
+	require.NoError(t, err)
+	c.AddTrustedUserCAKey(ca)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	require.Len(t, cc.WriteFiles, 2)
+	assert.Equal(t, "/etc/ssh/sshd_config.d/40-cloud-init.conf", cc.WriteFiles[1].Path)
+	assert.Equal(t, sshd.String()+"TrustedUserCAKeys /etc/ssh/trusted_user_ca_keys\n", cc.WriteFiles[1].Content)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, c.WriteISO(buf))
+
+	seed, err := cloudinit.OpenSeed(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	restored, err := seed.Config()
+	require.NoError(t, err)
+	assert.Equal(t, string(c.GenerateConfigContent()), string(restored.GenerateConfigContent()))
+}
+
+func TestSSHConfig(t *testing.T) {
+	key := testAuthorizedKey(t)
+
+	c := cloudinit.NewConfig()
+	require.NoError(t, c.AddAuthorizedKey(key))
+	require.ErrorIs(t, c.AddAuthorizedKey("ssh-rsa AAAAB3NzaC1yc2EA... nn0wn@pm.me"), cloudinit.ErrInvalidSSHConfig)
+	require.ErrorIs(t, c.AddAuthorizedKey(key+"\n"+key), cloudinit.ErrInvalidSSHConfig)
+
+	c.SetCloudConfig(&cloudinit.CloudConfig{SSH: cloudinit.SSHConfig{
+		GenKeyTypes:     []string{"ed25519"},
+		QuietKeygen:     true,
+		PublishHostKeys: &cloudinit.SSHPublishHostKeys{Enabled: true, Blacklist: []string{"rsa"}},
+	}})
+
+	content := string(c.GenerateConfigContent())
+	assert.Contains(t, content, "ssh_authorized_keys:\n    - "+key+"\n")
+	assert.Contains(t, content, "ssh_genkeytypes:\n    - ed25519\n")
+	assert.Contains(t, content, "ssh_quiet_keygen: true\n")
+	assert.Contains(t, content, "ssh_publish_hostkeys:\n    enabled: true\n    blacklist:\n        - rsa\n")
+
+	require.NoError(t, cloudinit.SSHConfig{AuthorizedKeys: []string{key}, GenKeyTypes: []string{"rsa"}}.Validate())
+	require.Error(t, cloudinit.SSHConfig{GenKeyTypes: []string{"ed448"}}.Validate())
+	require.Error(t, cloudinit.SSHConfig{AuthorizedKeys: []string{"not a key"}}.Validate())
+
+	// The inlined ssh section is validated when the seed is written.
+	c.SetCloudConfig(&cloudinit.CloudConfig{SSH: cloudinit.SSHConfig{AuthorizedKeys: []string{"not a key"}}})
+	assert.Nil(t, c.GenerateConfigContent())
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidSSHConfig)
+}
@@ -0,0 +1,153 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrVendorDataUnsupported is returned when vendor-data is set for a data
+// source whose seed has no vendor-data file.
+var ErrVendorDataUnsupported = errors.New("vendor-data is not supported by the data source")
+
+// configDriveVendorDataKey is the key of vendor_data.json cloud-init reads
+// the vendor-data from.
+const configDriveVendorDataKey = "cloud-init"
+
+// SetVendorConfig sets the cloud-config of the platform, written as
+// vendor-data to the NoCloud and ConfigDrive seeds. The reporting and output
+// sections of the user cloud-config are moved to the vendor-data too, so the
+// webhook credentials are not part of the user-data. cloud-init merges the
+// user-data over the vendor-data, so the user-data can still override them.
+// The EC2 and GCE seeds have no vendor-data, their writers return
+// ErrVendorDataUnsupported.
+func (c *Config) SetVendorConfig(cc *CloudConfig) {
+	c.vendorConfig = cc
+}
+
+// GenerateVendorDataContent returns the vendor-data, or nil if there is none
+// or it cannot be generated. The seed writers, e.g. WriteISO, report the error.
+func (c *Config) GenerateVendorDataContent() []byte {
+	content, err := c.generateVendorDataContent()
+	if err != nil {
+		return nil
+	}
+
+	return content
+}
+
+// hasVendorData reports whether the seed has vendor-data, i.e. there is a
+// vendor cloud-config and the data source supports vendor-data.
+func (c *Config) hasVendorData() bool {
+	return c.vendorConfig != nil && c.supportsVendorData()
+}
+
+// supportsVendorData reports whether the seed of the data source has a vendor-data file.
+func (c *Config) supportsVendorData() bool {
+	return c.dataSourceType != string(DataSourceEC2) && c.dataSourceType != string(DataSourceGCE)
+}
+
+// generateVendorData returns the vendor cloud-config with the reporting and
+// output sections of the user cloud-config moved in. The settings of the
+// vendor cloud-config take precedence.
+func (c *Config) generateVendorData() (*CloudConfig, error) {
+	cc, err := cloneCloudConfig(c.vendorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cloudConfig != nil {
+		if c.cloudConfig.Reporting != nil {
+			reporting := maps.Clone(c.cloudConfig.Reporting)
+			maps.Copy(reporting, cc.Reporting)
+			cc.Reporting = reporting
+		}
+
+		if cc.Output == nil {
+			cc.Output = c.cloudConfig.Output
+		}
+	}
+
+	if err := ValidateReporting(cc.Reporting); err != nil {
+		return nil, err
+	}
+
+	if cc.Output != nil {
+		if err := cc.Output.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cc, nil
+}
+
+// generateVendorDataContent returns the vendor-data as a cloud-config file,
+// or nil if there is none.
+func (c *Config) generateVendorDataContent() ([]byte, error) {
+	if !c.hasVendorData() {
+		return nil, nil
+	}
+
+	cc, err := c.generateVendorData()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("#cloud-config\n")
+
+	if err := yaml.NewEncoder(buf).Encode(cc); err != nil {
+		return nil, fmt.Errorf("failed to marshal vendor-data: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// seedVendorData returns the vendor-data file of the data source, or nil if
+// there is no vendor-data.
+func (c *Config) seedVendorData() ([]byte, error) {
+	if c.vendorConfig != nil && !c.supportsVendorData() {
+		return nil, fmt.Errorf("%w: %s", ErrVendorDataUnsupported, c.dataSourceType)
+	}
+
+	content, err := c.generateVendorDataContent()
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	if c.dataSourceType == string(DataSourceConfigDrive) {
+		data, err := json.Marshal(map[string]string{configDriveVendorDataKey: string(content)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal vendor_data.json: %w", err)
+		}
+
+		return data, nil
+	}
+
+	return content, nil
+}
+
+// applyVendorData sets the vendor cloud-config from the vendor-data file of a seed.
+func (c *Config) applyVendorData(dataSource DataSourceType, data []byte) error {
+	if dataSource == DataSourceConfigDrive {
+		var vd map[string]string
+		if err := json.Unmarshal(data, &vd); err != nil {
+			return fmt.Errorf("failed to parse vendor_data.json: %w", err)
+		}
+
+		data = []byte(vd[configDriveVendorDataKey])
+	}
+
+	cc := new(CloudConfig)
+	if err := yaml.Unmarshal(data, cc); err != nil {
+		return fmt.Errorf("failed to parse vendor-data: %w", err)
+	}
+
+	c.vendorConfig = cc
+
+	return nil
+}
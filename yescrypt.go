@@ -0,0 +1,380 @@
+package cloudinit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The pwxform settings of the yescrypt "j" flavor, YESCRYPT_DEFAULTS in libxcrypt.
+const (
+	yescryptFlags     = 0xb6 // RW, ROUNDS_6, GATHER_4, SIMPLE_2, SBOX_12K
+	yescryptFlagRW    = 0x002
+	yescryptPWXSimple = 2
+	yescryptPWXGather = 4
+	yescryptPWXRounds = 6
+	yescryptSWidth    = 8
+	yescryptPWXWords  = yescryptPWXGather * yescryptPWXSimple * 2
+	yescryptSWords    = 3 * (1 << yescryptSWidth) * yescryptPWXSimple * 2
+	yescryptSMask     = ((1 << yescryptSWidth) - 1) * yescryptPWXSimple * 8
+	yescryptSaltBytes = 16
+
+	// yescryptDefaultCost and yescryptBlockSize give N=4096 and r=32, the
+	// "$y$j9T$" parameters libxcrypt uses by default.
+	yescryptDefaultCost = 12
+	yescryptBlockSize   = 32
+)
+
+// yescryptHash hashes the password with yescrypt, N being 2^cost.
+// For more information see: https://www.openwall.com/yescrypt/
+func yescryptHash(password string, cost int, random io.Reader) (string, error) {
+	if cost == 0 {
+		cost = yescryptDefaultCost
+	}
+
+	if cost < 1 || cost > 24 {
+		return "", fmt.Errorf("invalid yescrypt cost %d", cost)
+	}
+
+	salt := make([]byte, yescryptSaltBytes)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return "", fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	setting := []byte("$y$")
+	setting = yescryptEncodeUint32(setting, yescryptFlagRW+(yescryptFlags>>2), 0)
+	setting = yescryptEncodeUint32(setting, uint32(cost), 1)
+	setting = yescryptEncodeUint32(setting, yescryptBlockSize, 1)
+	setting = append(setting, '$')
+	setting = yescryptEncode64(setting, salt)
+
+	dk := yescryptKDF([]byte(password), salt, 1<<cost, yescryptBlockSize)
+
+	out := append(setting, '$')
+	out = yescryptEncode64(out, dk)
+
+	return string(out), nil
+}
+
+// yescryptKDF is yescrypt_kdf with p=1, t=0 and no ROM, returning 32 bytes.
+func yescryptKDF(password, salt []byte, n uint64, r int) []byte {
+	if n*uint64(r) >= 0x20000 && n >= 0x100 {
+		password = yescryptKDFBody(password, salt, n>>6, r, true)
+	}
+
+	return yescryptKDFBody(password, salt, n, r, false)
+}
+
+func yescryptKDFBody(password, salt []byte, n uint64, r int, prehash bool) []byte {
+	key := []byte("yescrypt-prehash")
+	if !prehash {
+		key = key[:8]
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(password)
+	passwd := mac.Sum(nil)
+
+	b := pbkdf2.Key(passwd, salt, 1, 128*r, sha256.New)
+	copy(passwd, b[:32])
+
+	yescryptSMix(b, r, n, passwd)
+
+	dk := pbkdf2.Key(passwd, b, 1, 32, sha256.New)
+	if prehash {
+		return dk
+	}
+
+	mac = hmac.New(sha256.New, dk)
+	mac.Write([]byte("Client Key"))
+	clientKey := mac.Sum(nil)
+	storedKey := sha256.Sum256(clientKey)
+
+	return storedKey[:]
+}
+
+// yescryptPWXform holds the S-boxes and write pointer of pwxform.
+type yescryptPWXform struct {
+	s          []uint32
+	s0, s1, s2 []uint32
+	w          int
+}
+
+// yescryptSMix is smix for p=1 and t=0. The blocks are kept in the SIMD
+// shuffled word order of the reference implementation, which pwxform
+// depends on.
+func yescryptSMix(b []byte, r int, n uint64, passwd []byte) {
+	s := 32 * r
+	v := make([]uint32, uint64(s)*n)
+	xy := make([]uint32, 2*s)
+
+	nloop := (n + 2) / 3
+	nloop = (nloop + 1) &^ 1
+
+	ctx := &yescryptPWXform{s: make([]uint32, yescryptSWords)}
+	yescryptSMix1(b, 1, yescryptSWords/32, false, ctx.s, xy, nil)
+	ctx.s2 = ctx.s[:1<<yescryptSWidth*yescryptPWXSimple*2]
+	ctx.s1 = ctx.s[len(ctx.s2) : 2*len(ctx.s2)]
+	ctx.s0 = ctx.s[2*len(ctx.s2):]
+
+	mac := hmac.New(sha256.New, b[(s-16)*4:s*4])
+	mac.Write(passwd)
+	copy(passwd, mac.Sum(nil))
+
+	yescryptSMix1(b, r, n, true, v, xy, ctx)
+	yescryptSMix2(b, r, p2floor(n), nloop, v, xy, ctx)
+}
+
+func yescryptLoad(x []uint32, b []byte, r int) {
+	for k := range 2 * r {
+		for i := range 16 {
+			x[k*16+i] = binary.LittleEndian.Uint32(b[(k*16+i*5%16)*4:])
+		}
+	}
+}
+
+func yescryptStore(b []byte, x []uint32, r int) {
+	for k := range 2 * r {
+		for i := range 16 {
+			binary.LittleEndian.PutUint32(b[(k*16+i*5%16)*4:], x[k*16+i])
+		}
+	}
+}
+
+func yescryptSMix1(b []byte, r int, n uint64, rw bool, v, xy []uint32, ctx *yescryptPWXform) {
+	s := 32 * r
+	x, y := xy[:s], xy[s:]
+
+	yescryptLoad(x, b, r)
+
+	for i := range n {
+		copy(v[i*uint64(s):], x)
+
+		if rw && i > 1 {
+			j := yescryptWrap(yescryptIntegerify(x, r), i)
+			xorWords(x, v[j*uint64(s):(j+1)*uint64(s)])
+		}
+
+		if ctx != nil {
+			yescryptBlockMixPWXform(x, r, ctx)
+		} else {
+			yescryptBlockMixSalsa8(x, y, r)
+		}
+	}
+
+	yescryptStore(b, x, r)
+}
+
+func yescryptSMix2(b []byte, r int, n, nloop uint64, v, xy []uint32, ctx *yescryptPWXform) {
+	s := uint64(32 * r)
+	x := xy[:s]
+
+	yescryptLoad(x, b, r)
+
+	for range nloop {
+		j := yescryptIntegerify(x, r) & (n - 1)
+		vj := v[j*s : (j+1)*s]
+
+		xorWords(x, vj)
+		copy(vj, x)
+
+		yescryptBlockMixPWXform(x, r, ctx)
+	}
+
+	yescryptStore(b, x, r)
+}
+
+func yescryptBlockMixSalsa8(b, y []uint32, r int) {
+	var x [16]uint32
+
+	copy(x[:], b[(2*r-1)*16:])
+
+	for i := range 2 * r {
+		xorWords(x[:], b[i*16:(i+1)*16])
+		yescryptSalsa20(&x, 8)
+		copy(y[i*16:], x[:])
+	}
+
+	for i := range r {
+		copy(b[i*16:(i+1)*16], y[(2*i)*16:])
+		copy(b[(i+r)*16:(i+r+1)*16], y[(2*i+1)*16:])
+	}
+}
+
+func yescryptBlockMixPWXform(b []uint32, r int, ctx *yescryptPWXform) {
+	var x [yescryptPWXWords]uint32
+
+	r1 := 128 * r / (yescryptPWXWords * 4)
+
+	copy(x[:], b[(r1-1)*yescryptPWXWords:])
+
+	for i := range r1 {
+		if r1 > 1 {
+			xorWords(x[:], b[i*yescryptPWXWords:(i+1)*yescryptPWXWords])
+		}
+
+		ctx.pwxform(&x)
+		copy(b[i*yescryptPWXWords:], x[:])
+	}
+
+	i := (r1 - 1) * yescryptPWXWords / 16
+
+	var blk [16]uint32
+
+	copy(blk[:], b[i*16:])
+	yescryptSalsa20(&blk, 2)
+	copy(b[i*16:], blk[:])
+}
+
+func (ctx *yescryptPWXform) pwxform(x *[yescryptPWXWords]uint32) {
+	s0, s1, s2 := ctx.s0, ctx.s1, ctx.s2
+	w := ctx.w
+
+	for i := range yescryptPWXRounds {
+		for j := range yescryptPWXGather {
+			base := j * yescryptPWXSimple * 2
+			p0 := int(x[base]&yescryptSMask) / 4
+			p1 := int(x[base+1]&yescryptSMask) / 4
+
+			for k := range yescryptPWXSimple {
+				o := base + k*2
+				v := uint64(x[o+1]) * uint64(x[o])
+				v += uint64(s0[p0+k*2+1])<<32 | uint64(s0[p0+k*2])
+				v ^= uint64(s1[p1+k*2+1])<<32 | uint64(s1[p1+k*2])
+
+				x[o], x[o+1] = uint32(v), uint32(v>>32)
+
+				if i != 0 && i != yescryptPWXRounds-1 {
+					s2[w*2], s2[w*2+1] = uint32(v), uint32(v>>32)
+					w++
+				}
+			}
+		}
+	}
+
+	ctx.s0, ctx.s1, ctx.s2 = s2, s0, s1
+	ctx.w = w & ((1<<yescryptSWidth)*yescryptPWXSimple - 1)
+}
+
+// yescryptSalsa20 applies the Salsa20 core to a block in shuffled word order.
+func yescryptSalsa20(b *[16]uint32, rounds int) {
+	var x [16]uint32
+	for i := range 16 {
+		x[i*5%16] = b[i]
+	}
+
+	for i := 0; i < rounds; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+
+	for i := range 16 {
+		b[i] += x[i*5%16]
+	}
+}
+
+// yescryptIntegerify returns the first 64 bits of the last 64 byte block.
+func yescryptIntegerify(b []uint32, r int) uint64 {
+	x := b[(2*r-1)*16:]
+	return uint64(x[13])<<32 | uint64(x[0])
+}
+
+func yescryptWrap(x, i uint64) uint64 {
+	n := p2floor(i)
+	return (x & (n - 1)) + (i - n)
+}
+
+// p2floor returns the largest power of 2 not greater than x.
+func p2floor(x uint64) uint64 {
+	return 1 << (bits.Len64(x) - 1)
+}
+
+func xorWords(dst, src []uint32) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// yescryptEncodeUint32 appends the variable length encoding yescrypt uses for parameters.
+func yescryptEncodeUint32(dst []byte, src, minimum uint32) []byte {
+	start, end, chars, nbits := uint32(0), uint32(47), 1, uint32(0)
+
+	src -= minimum
+
+	for {
+		count := (end + 1 - start) << nbits
+		if src < count {
+			break
+		}
+
+		start = end + 1
+		end = start + (62-end)/2
+		src -= count
+		chars++
+		nbits += 6
+	}
+
+	dst = append(dst, cryptAlphabet[start+(src>>nbits)])
+	for chars--; chars > 0; chars-- {
+		nbits -= 6
+		dst = append(dst, cryptAlphabet[src>>nbits&0x3f])
+	}
+
+	return dst
+}
+
+// yescryptEncode64 appends src in yescrypt's little-endian base64 encoding.
+func yescryptEncode64(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		value, nbits := uint32(0), 0
+
+		for nbits < 24 && i < len(src) {
+			value |= uint32(src[i]) << nbits
+			nbits += 8
+			i++
+		}
+
+		for ; nbits > 0; nbits -= 6 {
+			dst = append(dst, cryptAlphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+
+	return dst
+}
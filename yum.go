@@ -0,0 +1,123 @@
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidYumConfig is returned for invalid yum_repos and rh_subscription settings.
+var ErrInvalidYumConfig = errors.New("invalid yum configuration")
+
+// YumRepo is a repository of the yum_add_repo module, written to
+// /etc/yum.repos.d/<id>.repo.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#yum-add-repo
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type YumRepo struct {
+	// Name is the human-readable name of the repository.
+	Name string `yaml:"name,omitempty"`
+	// BaseURL is the URL of the repository.
+	BaseURL string `yaml:"baseurl,omitempty"`
+	// Metalink is the URL of a metalink file, used instead of BaseURL.
+	Metalink string `yaml:"metalink,omitempty"`
+	// MirrorList is the URL of a mirror list, used instead of BaseURL.
+	MirrorList string `yaml:"mirrorlist,omitempty"`
+	// Enabled enables the repository, true by default in dnf.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// GPGCheck enables the signature check of the packages.
+	GPGCheck *bool `yaml:"gpgcheck,omitempty"`
+	// GPGKey is the URL of the signing key, e.g. file:///etc/pki/rpm-gpg/RPM-GPG-KEY-example.
+	GPGKey string `yaml:"gpgkey,omitempty"`
+	// Priority is the priority of the repository, lower is preferred, 99 by default in dnf.
+	Priority int `yaml:"priority,omitempty"`
+}
+
+// Validate checks that the repository has a source and a valid priority.
+func (r YumRepo) Validate() error {
+	if r.BaseURL == "" && r.Metalink == "" && r.MirrorList == "" {
+		return fmt.Errorf("%w: repository without baseurl, metalink or mirrorlist", ErrInvalidYumConfig)
+	}
+
+	if r.Priority < 0 || r.Priority > 99 {
+		return fmt.Errorf("%w: priority %d out of range", ErrInvalidYumConfig, r.Priority)
+	}
+
+	if r.GPGCheck != nil && *r.GPGCheck && r.GPGKey == "" {
+		return fmt.Errorf("%w: gpgcheck without gpgkey", ErrInvalidYumConfig)
+	}
+
+	return nil
+}
+
+// ValidateYumRepos checks the repositories and their IDs, which are used as file names.
+func ValidateYumRepos(repos map[string]YumRepo) error {
+	for _, id := range slices.Sorted(maps.Keys(repos)) {
+		if id == "" || strings.ContainsAny(id, "/ \t\r\n") {
+			return fmt.Errorf("%w: invalid repository ID %q", ErrInvalidYumConfig, id)
+		}
+
+		if err := repos[id].Validate(); err != nil {
+			return fmt.Errorf("repository %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// RHSubscription holds the configuration of the rh_subscription module, which
+// registers RHEL guests with subscription-manager.
+// For more information see: https://cloudinit.readthedocs.io/en/latest/reference/modules.html#red-hat-subscription
+//
+//nolint:tagliatelle // This format is required by the cloud-init metadata.
+type RHSubscription struct {
+	// Username is the Red Hat account, used with Password.
+	Username string `yaml:"username,omitempty"`
+	// Password is the password of Username.
+	Password string `yaml:"password,omitempty"`
+	// ActivationKey is the activation key, used with Org.
+	ActivationKey string `yaml:"activation-key,omitempty"`
+	// Org is the organization ID of ActivationKey.
+	Org string `yaml:"org,omitempty"`
+	// AutoAttach attaches the best matching subscriptions.
+	AutoAttach *bool `yaml:"auto-attach,omitempty"`
+	// ServiceLevel is the service level used with AutoAttach, e.g. "self-support".
+	ServiceLevel string `yaml:"service-level,omitempty"`
+	// AddPool are the pool IDs to attach.
+	AddPool []string `yaml:"add-pool,omitempty"`
+	// EnableRepo are the repositories to enable.
+	EnableRepo []string `yaml:"enable-repo,omitempty"`
+	// DisableRepo are the repositories to disable.
+	DisableRepo []string `yaml:"disable-repo,omitempty"`
+	// RHSMBaseURL is the base URL of the content server, e.g. for Satellite.
+	RHSMBaseURL string `yaml:"rhsm-baseurl,omitempty"`
+	// ServerHostname is the hostname of the subscription server, e.g. for Satellite.
+	ServerHostname string `yaml:"server-hostname,omitempty"`
+}
+
+// Validate checks that exactly one of the credential pairs is complete, as
+// subscription-manager does not accept both.
+func (s *RHSubscription) Validate() error {
+	switch {
+	case s.ActivationKey != "" && s.Username != "":
+		return fmt.Errorf("%w: both activation key and username given", ErrInvalidYumConfig)
+	case s.ActivationKey != "" && s.Org == "":
+		return fmt.Errorf("%w: activation key without org", ErrInvalidYumConfig)
+	case s.Username != "" && s.Password == "":
+		return fmt.Errorf("%w: username without password", ErrInvalidYumConfig)
+	case s.ActivationKey == "" && s.Username == "":
+		return fmt.Errorf("%w: neither activation key nor username given", ErrInvalidYumConfig)
+	case s.ServiceLevel != "" && (s.AutoAttach == nil || !*s.AutoAttach):
+		return fmt.Errorf("%w: service level without auto-attach", ErrInvalidYumConfig)
+	}
+
+	for _, id := range slices.Concat(s.AddPool, s.EnableRepo, s.DisableRepo) {
+		if id == "" || strings.ContainsAny(id, " \t\r\n") {
+			return fmt.Errorf("%w: invalid pool or repository ID %q", ErrInvalidYumConfig, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package cloudinit_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cloudinit "go.pilab.hu/cloud/cloud-init"
+	"gopkg.in/yaml.v3"
+)
+
+func TestYumReposAndRHSubscription(t *testing.T) {
+	enabled, autoAttach := true, true
+	repos := map[string]cloudinit.YumRepo{
+		"epel": {
+			Name:     "Extra Packages for Enterprise Linux",
+			Metalink: "https://mirrors.fedoraproject.org/metalink?repo=epel-9&arch=$basearch",
+			Enabled:  &enabled,
+			GPGCheck: &enabled,
+			GPGKey:   "https://dl.fedoraproject.org/pub/epel/RPM-GPG-KEY-EPEL-9",
+			Priority: 10,
+		},
+	}
+	sub := &cloudinit.RHSubscription{
+		ActivationKey: "key",
+		Org:           "1234",
+		AutoAttach:    &autoAttach,
+		AddPool:       []string{"8a85f98c6d"},
+		EnableRepo:    []string{"rhel-9-for-x86_64-appstream-rpms"},
+	}
+	require.NoError(t, cloudinit.ValidateYumRepos(repos))
+	require.NoError(t, sub.Validate())
+
+	c := cloudinit.NewConfig()
+	c.SetCloudConfig(&cloudinit.CloudConfig{YumRepos: repos, RHSubscription: sub})
+
+	content := c.GenerateConfigContent()
+	assert.Contains(t, string(content), "activation-key: key\n")
+	assert.Contains(t, string(content), "gpgcheck: true\n")
+
+	var cc cloudinit.CloudConfig
+	require.NoError(t, yaml.Unmarshal(content, &cc))
+	assert.Equal(t, repos, cc.YumRepos)
+	assert.Equal(t, sub, cc.RHSubscription)
+
+	require.ErrorIs(t, cloudinit.ValidateYumRepos(map[string]cloudinit.YumRepo{"a/b": repos["epel"]}),
+		cloudinit.ErrInvalidYumConfig)
+	require.ErrorIs(t, cloudinit.YumRepo{Name: "empty"}.Validate(), cloudinit.ErrInvalidYumConfig)
+	require.ErrorIs(t, (&cloudinit.RHSubscription{ActivationKey: "key"}).Validate(), cloudinit.ErrInvalidYumConfig)
+	require.ErrorIs(t, (&cloudinit.RHSubscription{Username: "user"}).Validate(), cloudinit.ErrInvalidYumConfig)
+
+	// The repository IDs are file names on the guest, so generation fails
+	// instead of writing them unchecked.
+	c.SetCloudConfig(&cloudinit.CloudConfig{YumRepos: map[string]cloudinit.YumRepo{"../epel": repos["epel"]}})
+	assert.Nil(t, c.GenerateConfigContent())
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidYumConfig)
+
+	c.SetCloudConfig(&cloudinit.CloudConfig{RHSubscription: &cloudinit.RHSubscription{ActivationKey: "key"}})
+	require.ErrorIs(t, c.WriteISO(io.Discard), cloudinit.ErrInvalidYumConfig)
+}
+
+func TestGuestAgentPackageManager(t *testing.T) {
+	for _, tc := range []struct {
+		distro  cloudinit.Distro
+		command string
+	}{
+		{"", "systemctl enable qemu-guest-agent --now"},
+		{cloudinit.DistroRocky, "systemctl enable qemu-guest-agent --now"},
+		{cloudinit.DistroAlpine, "rc-update add qemu-guest-agent default && rc-service qemu-guest-agent start"},
+	} {
+		c := cloudinit.NewConfig()
+		c.SetDistro(tc.distro)
+		c.EnableGuestAgent()
+
+		var cc cloudinit.CloudConfig
+		require.NoError(t, yaml.Unmarshal(c.GenerateConfigContent(), &cc))
+		assert.Equal(t, []cloudinit.Package{{Name: "qemu-guest-agent"}}, cc.Packages, tc.distro)
+		assert.Equal(t, []cloudinit.Command{cloudinit.ShellCommand(tc.command)}, cc.RunCommands, tc.distro)
+	}
+
+	assert.Equal(t, "dnf", cloudinit.DistroRocky.PackageManager().Name)
+	assert.Equal(t, "apk", cloudinit.DistroAlpine.PackageManager().Name)
+}